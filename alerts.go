@@ -0,0 +1,634 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The kinds of alert rule
+const (
+	// An RPC node's dag tips haven't changed for the rule's duration
+	ruleTipStall = "tipstall"
+	// An RPC node has fewer peers than the threshold
+	ruleLowPeers = "lowpeers"
+	// The max tip heights of the RPC nodes differ by more than the threshold
+	ruleHeightSpread = "heightspread"
+	// The number of online census nodes is more than the threshold percent below the highest count within the rule's
+	// duration
+	ruleCensusDrop = "censusdrop"
+	// An RPC node can't be polled
+	ruleRPCDown = "rpcdown"
+)
+
+// The states of an alert
+const (
+	// The rule's condition holds, but hasn't held for long enough to fire
+	alertPending = "pending"
+	alertFiring = "firing"
+	alertResolved = "resolved"
+)
+
+const (
+	// How long webhook requests can take
+	webhookTimeout = time.Second * 10
+
+	// How many notifications can wait to be sent, before new ones are dropped
+	webhookQueue = 100
+)
+
+// alertRule is a condition that alerts are raised for
+type alertRule struct {
+	Name string
+	Kind string
+	// The RPC node the rule applies to, or -1 for every RPC node
+	Node int
+	Threshold float64
+	// The stall duration of tipstall rules, and the comparison window of censusdrop rules
+	Duration time.Duration
+	// How long the condition must hold before the alert fires
+	For time.Duration
+}
+
+// newAlertRule returns the alert rule for the config
+func newAlertRule(c alertRuleConfig) (alertRule, error) {
+	rule := alertRule{
+		Name: c.Name,
+		Kind: c.Kind,
+		Node: -1,
+		Threshold: c.Threshold,
+	}
+	if len(rule.Name) == 0 {
+		return rule, fmt.Errorf("alert rule has no name")
+	}
+	if c.Node != nil {
+		rule.Node = *c.Node
+	}
+
+	var err error
+	if len(c.Duration) > 0 {
+		rule.Duration, err = time.ParseDuration(c.Duration)
+		if err != nil {
+			return rule, fmt.Errorf("alert rule %s has an invalid duration '%s': %s", rule.Name, c.Duration, err)
+		}
+	}
+	if len(c.For) > 0 {
+		rule.For, err = time.ParseDuration(c.For)
+		if err != nil {
+			return rule, fmt.Errorf("alert rule %s has an invalid for duration '%s': %s", rule.Name, c.For, err)
+		}
+	}
+
+	switch rule.Kind {
+	case ruleTipStall, ruleCensusDrop:
+		if rule.Duration <= 0 {
+			return rule, fmt.Errorf("alert rule %s needs a duration", rule.Name)
+		}
+	case ruleLowPeers, ruleHeightSpread, ruleRPCDown:
+	default:
+		return rule, fmt.Errorf("alert rule %s has unknown kind '%s' (use %s, %s, %s, %s or %s)", rule.Name, rule.Kind,
+			ruleTipStall, ruleLowPeers, ruleHeightSpread, ruleCensusDrop, ruleRPCDown)
+	}
+
+	return rule, nil
+}
+
+// appliesTo returns true if the rule applies to the RPC node
+func (r alertRule) appliesTo(id int) bool {
+	return r.Node < 0 || r.Node == id
+}
+
+// Description returns what the rule alerts on
+func (r alertRule) Description() string {
+	node := "any rpc node"
+	if r.Node >= 0 {
+		node = rpcSubject(r.Node)
+	}
+
+	switch r.Kind {
+	case ruleTipStall:
+		return fmt.Sprintf("%s has no new tip for %s", node, r.Duration)
+	case ruleLowPeers:
+		return fmt.Sprintf("%s has fewer than %g peers", node, r.Threshold)
+	case ruleHeightSpread:
+		return fmt.Sprintf("tip heights of rpc nodes differ by more than %g", r.Threshold)
+	case ruleCensusDrop:
+		return fmt.Sprintf("online census nodes drop by more than %g%% within %s", r.Threshold, r.Duration)
+	case ruleRPCDown:
+		return fmt.Sprintf("%s is unreachable", node)
+	}
+	return r.Kind
+}
+
+// alert is raised when a rule's condition holds for a subject, like an RPC node
+type alert struct {
+	Rule string `json:"rule"`
+	Kind string `json:"kind"`
+	// What the alert is about, like "rpc node 0" or "census"
+	Subject string `json:"subject"`
+	Status string `json:"status"`
+	Message string `json:"message"`
+	// The value that was compared with the rule's threshold
+	Value float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	// When the condition started holding, when the alert fired and when it was resolved
+	Since time.Time `json:"since"`
+	FiredAt time.Time `json:"firedAt"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+// alertKey identifies a rule's alert for a subject, so that it's only raised once while its condition holds
+func alertKey(rule, subject string) string {
+	return rule + "/" + subject
+}
+
+// condition is the result of evaluating a rule for a subject
+type condition struct {
+	Subject string
+	Holds bool
+	Value float64
+	Message string
+	// Whether the rule couldn't be evaluated for the subject, in which case the message says why
+	Stale bool
+}
+
+// rpcObservation is what an RPC node looked like when it was polled for alerting
+type rpcObservation struct {
+	Up bool
+	Err error
+	MaxHeight int32
+	Peers int
+	// When the node's tips last changed
+	TipsChanged time.Time
+}
+
+// censusSample is the number of online census nodes at a point in time
+type censusSample struct {
+	Time time.Time
+	Online int
+}
+
+// alertEngine evaluates alert rules on an interval, and notifies webhooks when alerts fire and resolve
+type alertEngine struct {
+	rules []alertRule
+	webhooks []string
+	interval time.Duration
+
+	// How many resolved alerts are kept
+	historySize int
+
+	// Active (pending or firing) alerts by key, and resolved alerts, newest first
+	active map[string]*alert
+	recent []alert
+	lastEvaluated time.Time
+
+	// Maps RPC node id to the hash of its tips and when they last changed. Only used by the goroutine evaluating rules.
+	tipHashes map[int]string
+	tipsChanged map[int]time.Time
+
+	// Online census node counts, oldest first. Only used by the goroutine evaluating rules.
+	censusHistory []censusSample
+
+	lock sync.RWMutex
+
+	// Notifications waiting to be sent to the webhooks
+	notifications chan alert
+	client *http.Client
+
+	started int32
+	shutdown int32
+	wg sync.WaitGroup
+	quit chan struct{}
+}
+
+// newAlertEngine returns an alertEngine for the config
+func newAlertEngine(c alertsConfig) (*alertEngine, error) {
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alert interval '%s': %s", c.Interval, err)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("alert interval must be positive")
+	}
+	if c.History < 0 {
+		return nil, fmt.Errorf("alert history can't be negative")
+	}
+
+	a := alertEngine{
+		webhooks: c.Webhooks,
+		interval: interval,
+		historySize: c.History,
+		active: make(map[string]*alert),
+		tipHashes: make(map[int]string),
+		tipsChanged: make(map[int]time.Time),
+		notifications: make(chan alert, webhookQueue),
+		client: &http.Client{Timeout: webhookTimeout},
+		quit: make(chan struct{}),
+	}
+
+	names := make(map[string]bool)
+	for _, rc := range c.Rules {
+		rule, err := newAlertRule(rc)
+		if err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %s is configured more than once", rule.Name)
+		}
+		names[rule.Name] = true
+
+		a.rules = append(a.rules, rule)
+	}
+
+	return &a, nil
+}
+
+// observeRPCNodes polls each RPC node for the state that rules are evaluated against
+func (a *alertEngine) observeRPCNodes(now time.Time) map[int]rpcObservation {
+	obs := make(map[int]rpcObservation)
+	for id, c := range clients {
+		tips, err := c.GetDAGTips()
+		if err != nil {
+			obs[id] = rpcObservation{Err: err}
+			continue
+		}
+
+		peers, err := c.GetPeerInfo()
+		if err != nil {
+			obs[id] = rpcObservation{Err: err}
+			continue
+		}
+
+		if a.tipHashes[id] != tips.Hash {
+			a.tipHashes[id] = tips.Hash
+			a.tipsChanged[id] = now
+		}
+
+		obs[id] = rpcObservation{
+			Up: true,
+			MaxHeight: tips.MaxHeight,
+			Peers: len(peers),
+			TipsChanged: a.tipsChanged[id],
+		}
+	}
+
+	return obs
+}
+
+// observeCensus records the number of online census nodes, and discards samples that no rule needs anymore
+func (a *alertEngine) observeCensus(now time.Time) {
+	if e == nil {
+		return
+	}
+
+	var keep time.Duration
+	for _, rule := range a.rules {
+		if rule.Kind == ruleCensusDrop && rule.Duration > keep {
+			keep = rule.Duration
+		}
+	}
+	if keep == 0 {
+		return
+	}
+
+	online := 0
+	for _, n := range e.Nodes() {
+		r := soterdNodeRow{Online: n.Online, Stale: n.IsStale(e.Interval * 3)}
+		if r.Status() == "online" {
+			online++
+		}
+	}
+
+	history := append(a.censusHistory, censusSample{Time: now, Online: online})
+	cutoff := now.Add(-keep)
+	i := 0
+	for i < len(history) && history[i].Time.Before(cutoff) {
+		i++
+	}
+	a.censusHistory = history[i:]
+}
+
+// rpcSubject returns the subject of alerts about an RPC node
+func rpcSubject(id int) string {
+	return fmt.Sprintf("rpc node %d", id)
+}
+
+// evaluate returns the rule's conditions for its subjects. A subject without the data the rule needs, like an RPC node
+// that couldn't be polled, gets a condition that doesn't hold, so that an alert about it doesn't stay firing with stale
+// values. RPC nodes being unreachable is what the rpcdown rule is for.
+func (a *alertEngine) evaluate(rule alertRule, obs map[int]rpcObservation, now time.Time) []condition {
+	var conds []condition
+
+	switch rule.Kind {
+	case ruleRPCDown:
+		for id, o := range obs {
+			if !rule.appliesTo(id) {
+				continue
+			}
+			c := condition{Subject: rpcSubject(id), Holds: !o.Up}
+			if c.Holds {
+				c.Value = 1
+				c.Message = fmt.Sprintf("%s is unreachable: %s", c.Subject, o.Err)
+			}
+			conds = append(conds, c)
+		}
+
+	case ruleTipStall:
+		for id, o := range obs {
+			if !rule.appliesTo(id) {
+				continue
+			}
+			if !o.Up {
+				conds = append(conds, unreachableCondition(id, o))
+				continue
+			}
+			stalled := now.Sub(o.TipsChanged)
+			c := condition{
+				Subject: rpcSubject(id),
+				Holds: stalled >= rule.Duration,
+				Value: stalled.Minutes(),
+			}
+			c.Message = fmt.Sprintf("%s has had no new tip for %s", c.Subject, stalled.Round(time.Second))
+			conds = append(conds, c)
+		}
+
+	case ruleLowPeers:
+		for id, o := range obs {
+			if !rule.appliesTo(id) {
+				continue
+			}
+			if !o.Up {
+				conds = append(conds, unreachableCondition(id, o))
+				continue
+			}
+			c := condition{
+				Subject: rpcSubject(id),
+				Holds: float64(o.Peers) < rule.Threshold,
+				Value: float64(o.Peers),
+			}
+			c.Message = fmt.Sprintf("%s has %d peers, below %g", c.Subject, o.Peers, rule.Threshold)
+			conds = append(conds, c)
+		}
+
+	case ruleHeightSpread:
+		var min, max int32
+		count := 0
+		for id, o := range obs {
+			if !rule.appliesTo(id) || !o.Up {
+				continue
+			}
+			if count == 0 || o.MaxHeight < min {
+				min = o.MaxHeight
+			}
+			if count == 0 || o.MaxHeight > max {
+				max = o.MaxHeight
+			}
+			count++
+		}
+		if count < 2 {
+			conds = append(conds, condition{
+				Subject: "rpc nodes",
+				Stale: true,
+				Message: "tip heights can't be compared, since fewer than 2 rpc nodes are up",
+			})
+			break
+		}
+		spread := float64(max - min)
+		conds = append(conds, condition{
+			Subject: "rpc nodes",
+			Holds: spread > rule.Threshold,
+			Value: spread,
+			Message: fmt.Sprintf("tip heights of rpc nodes differ by %g (%d to %d), above %g", spread, min, max, rule.Threshold),
+		})
+
+	case ruleCensusDrop:
+		if len(a.censusHistory) == 0 {
+			break
+		}
+		cur := a.censusHistory[len(a.censusHistory) - 1].Online
+		peak := 0
+		cutoff := now.Add(-rule.Duration)
+		for _, s := range a.censusHistory {
+			if !s.Time.Before(cutoff) && s.Online > peak {
+				peak = s.Online
+			}
+		}
+		if peak == 0 {
+			break
+		}
+		drop := float64(peak - cur) * 100 / float64(peak)
+		conds = append(conds, condition{
+			Subject: "census",
+			Holds: drop > rule.Threshold,
+			Value: drop,
+			Message: fmt.Sprintf("census has %d online nodes, %.0f%% fewer than the %d online within %s", cur, drop, peak, rule.Duration),
+		})
+	}
+
+	return conds
+}
+
+// unreachableCondition returns a condition that doesn't hold, for an RPC node that couldn't be polled
+func unreachableCondition(id int, o rpcObservation) condition {
+	c := condition{Subject: rpcSubject(id), Stale: true}
+	c.Message = fmt.Sprintf("%s can't be evaluated, because it's unreachable: %s", c.Subject, o.Err)
+	return c
+}
+
+// update moves the rule's alerts between states for the conditions, and returns the alerts that fired or resolved
+//
+// The caller must hold lock.
+func (a *alertEngine) update(rule alertRule, conds []condition, now time.Time) []alert {
+	var changed []alert
+	for _, c := range conds {
+		key := alertKey(rule.Name, c.Subject)
+		al, exists := a.active[key]
+
+		if !c.Holds {
+			if !exists {
+				continue
+			}
+			delete(a.active, key)
+			if al.Status == alertFiring {
+				// An alert resolved because the rule can't be evaluated says so, rather than keeping the values it fired with
+				if c.Stale {
+					al.Value = c.Value
+					al.Message = c.Message
+				}
+				al.Status = alertResolved
+				al.ResolvedAt = now
+				a.addRecent(*al)
+				changed = append(changed, *al)
+			}
+			continue
+		}
+
+		if !exists {
+			al = &alert{
+				Rule: rule.Name,
+				Kind: rule.Kind,
+				Subject: c.Subject,
+				Status: alertPending,
+				Threshold: rule.Threshold,
+				Since: now,
+			}
+			a.active[key] = al
+		}
+		al.Value = c.Value
+		al.Message = c.Message
+
+		if al.Status == alertPending && now.Sub(al.Since) >= rule.For {
+			al.Status = alertFiring
+			al.FiredAt = now
+			changed = append(changed, *al)
+		}
+	}
+
+	return changed
+}
+
+// addRecent adds a resolved alert to the history, discarding the oldest ones beyond the history size
+//
+// The caller must hold lock.
+func (a *alertEngine) addRecent(al alert) {
+	a.recent = append([]alert{al}, a.recent...)
+	if len(a.recent) > a.historySize {
+		a.recent = a.recent[:a.historySize]
+	}
+}
+
+// check evaluates every rule, and queues notifications for the alerts that fired or resolved
+func (a *alertEngine) check() {
+	// Strip the monotonic clock reading, which isn't useful in notifications or on the page
+	now := time.Now().Round(0)
+
+	// Poll RPC nodes before taking the lock, so that the /alerts page isn't held up by slow nodes.
+	// Only this goroutine uses the tip and census history, so they don't need the lock.
+	obs := a.observeRPCNodes(now)
+	a.observeCensus(now)
+
+	a.lock.Lock()
+	var changed []alert
+	for _, rule := range a.rules {
+		changed = append(changed, a.update(rule, a.evaluate(rule, obs, now), now)...)
+	}
+	a.lastEvaluated = now
+	a.lock.Unlock()
+
+	for _, al := range changed {
+		log.Printf("Alert %s %s: %s", al.Rule, al.Status, al.Message)
+		if len(a.webhooks) == 0 {
+			continue
+		}
+
+		select {
+			case a.notifications <- al:
+			default:
+				log.Printf("Dropping notification of alert %s for %s: too many notifications are waiting to be sent", al.Rule, al.Subject)
+		}
+	}
+}
+
+// notify posts the alert as JSON to each webhook
+func (a *alertEngine) notify(al alert) {
+	body, err := json.Marshal(al)
+	if err != nil {
+		log.Printf("Failed to encode alert %s: %s", al.Rule, err)
+		return
+	}
+
+	for _, url := range a.webhooks {
+		resp, err := a.client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Failed to send alert %s to webhook %s: %s", al.Rule, url, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			log.Printf("Webhook %s rejected alert %s: %s", url, al.Rule, resp.Status)
+		}
+	}
+}
+
+// run evaluates the rules until the engine is stopped
+func (a *alertEngine) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	a.check()
+	for {
+		select {
+			case <-ticker.C:
+				a.check()
+			case <-a.quit:
+				return
+		}
+	}
+}
+
+// send delivers notifications to the webhooks until the engine is stopped
+func (a *alertEngine) send() {
+	defer a.wg.Done()
+
+	for {
+		select {
+			case al := <-a.notifications:
+				a.notify(al)
+			case <-a.quit:
+				return
+		}
+	}
+}
+
+// Start evaluating rules in a new goroutine
+func (a *alertEngine) Start() {
+	if atomic.AddInt32(&a.started, 1) != 1 {
+		return
+	}
+
+	a.wg.Add(2)
+	go a.run()
+	go a.send()
+}
+
+// Stop evaluating rules
+func (a *alertEngine) Stop() {
+	if atomic.AddInt32(&a.shutdown, 1) != 1 {
+		return
+	}
+
+	close(a.quit)
+	a.wg.Wait()
+}
+
+// status returns the rules, the active alerts (firing first, then by when they started) and the resolved alerts
+func (a *alertEngine) status() ([]alertRule, []alert, []alert, time.Time) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	active := make([]alert, 0, len(a.active))
+	for _, al := range a.active {
+		active = append(active, *al)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].Status != active[j].Status {
+			return active[i].Status == alertFiring
+		}
+		return active[i].Since.Before(active[j].Since)
+	})
+
+	return append([]alertRule{}, a.rules...), active, append([]alert{}, a.recent...), a.lastEvaluated
+}
@@ -0,0 +1,634 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soteria-dag/soterdash/census"
+	"github.com/soteria-dag/soterdash/rand"
+	"github.com/soteria-dag/soterd/rpcclient"
+)
+
+const (
+	// The path that version 1 of the JSON API is served under
+	apiPrefix = "/api/v1/"
+
+	// Where the OpenAPI document describing the JSON API is kept
+	apiSpecPath = "static/openapi.json"
+
+	// Page sizes for list responses
+	apiDefaultPerPage = 50
+	apiMaxPerPage = 500
+
+	// The most dag heights returned for a single dag range request
+	apiMaxDagRange = 100
+)
+
+// The body of an error response
+type apiErrorResponse struct {
+	Error *httpError `json:"error"`
+}
+
+// A page of a list response
+type apiPage struct {
+	Data interface{} `json:"data"`
+	// Pages are numbered starting from 1
+	Page int `json:"page"`
+	PerPage int `json:"perPage"`
+	// How many items there are across all pages
+	Total int `json:"total"`
+	Pages int `json:"pages"`
+}
+
+// Represents a block. Hashes are hex strings, as shown by soterd.
+type apiBlock struct {
+	Hash string `json:"hash"`
+	Version int32 `json:"version"`
+	Parents []string `json:"parents"`
+	MerkleRoot string `json:"merkleRoot"`
+	Timestamp time.Time `json:"timestamp"`
+	Bits string `json:"bits"`
+	Nonce uint32 `json:"nonce"`
+	Difficulty float64 `json:"difficulty"`
+	Height int32 `json:"height"`
+	Confirmations int64 `json:"confirmations"`
+	NextHashes []string `json:"nextHashes"`
+	// Hashes of the block's transactions
+	Transactions []string `json:"transactions"`
+	// How the block propagated across the RPC nodes, if we saw it arrive
+	Propagation *blockPropagation `json:"propagation,omitempty"`
+}
+
+// Represents the blocks at a dag height
+type apiHeight struct {
+	Height int32 `json:"height"`
+	Hashes []string `json:"hashes"`
+}
+
+// Represents a block in a dag range
+type apiDagBlock struct {
+	Hash string `json:"hash"`
+	Height int32 `json:"height"`
+	Parents []string `json:"parents"`
+	// If the block is blue in the GHOSTDAG coloring
+	Blue bool `json:"blue"`
+}
+
+// Represents the blocks of the dag between two heights
+type apiDag struct {
+	RPCNode int `json:"rpcNode"`
+	MinHeight int32 `json:"minHeight"`
+	MaxHeight int32 `json:"maxHeight"`
+	Blocks []apiDagBlock `json:"blocks"`
+}
+
+// Represents an RPC node's dag tips
+type apiTips struct {
+	RPCNode int `json:"rpcNode"`
+	Tips []string `json:"tips"`
+	VirtualHash string `json:"virtualHash"`
+	MinHeight int32 `json:"minHeight"`
+	MaxHeight int32 `json:"maxHeight"`
+	BlkCount uint32 `json:"blkCount"`
+}
+
+// Network timing measurements between soterdash and a census node, in nanoseconds
+type apiLatency struct {
+	Measured time.Time `json:"measured"`
+	Connect time.Duration `json:"connect"`
+	Handshake time.Duration `json:"handshake"`
+	Ping time.Duration `json:"ping"`
+}
+
+// Where a census node is located
+type apiGeo struct {
+	Country string `json:"country"`
+	CountryCode string `json:"countryCode"`
+	City string `json:"city"`
+	ASN uint `json:"asn"`
+	Organization string `json:"organization"`
+}
+
+// What a census agent last reported about a node
+type apiVantage struct {
+	Agent string `json:"agent"`
+	Online bool `json:"online"`
+	LastChecked time.Time `json:"lastChecked"`
+	Reported time.Time `json:"reported"`
+}
+
+// Represents a census node
+type apiNode struct {
+	Address string `json:"address"`
+	Version string `json:"version"`
+	Online bool `json:"online"`
+	Stale bool `json:"stale"`
+	LastChecked time.Time `json:"lastChecked"`
+	// Addresses of the nodes it's connected to
+	Connections []string `json:"connections"`
+	Latency *apiLatency `json:"latency,omitempty"`
+	Geo apiGeo `json:"geo"`
+	Vantages []apiVantage `json:"vantages"`
+}
+
+// Represents a connection between two census nodes
+type apiEdge struct {
+	A string `json:"a"`
+	B string `json:"b"`
+}
+
+// Represents the census node graph
+type apiNodeGraph struct {
+	Nodes []soterdNodeRow `json:"nodes"`
+	Edges []apiEdge `json:"edges"`
+}
+
+// renderAPI renders the data as a JSON response with the status
+func renderAPI(w http.ResponseWriter, status int, data interface{}) {
+	body, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(apiErrorResponse{Error: newHTTPError(status, "%s", err)})
+	}
+
+	setContentType(w, "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+// renderAPIErr renders the error as a JSON error response. Errors that aren't an httpError are internal server errors.
+func renderAPIErr(w http.ResponseWriter, err error) {
+	he, ok := err.(*httpError)
+	if !ok {
+		he = newHTTPError(http.StatusInternalServerError, "%s", err)
+	}
+	renderAPI(w, he.Status, apiErrorResponse{Error: he})
+}
+
+// parseAPIInt parses the query parameter as an integer, returning the default value if it isn't set
+func parseAPIInt(r *http.Request, key string, def int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if len(v) == 0 {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, newHTTPError(http.StatusBadRequest, "invalid %s parameter '%s'", key, v)
+	}
+	return i, nil
+}
+
+// parsePagination returns the page and page size requested with the page and per query parameters
+func parsePagination(r *http.Request) (int, int, error) {
+	page, err := parseAPIInt(r, "page", 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if page < 1 {
+		return 0, 0, newHTTPError(http.StatusBadRequest, "page must be 1 or more")
+	}
+
+	per, err := parseAPIInt(r, "per", apiDefaultPerPage)
+	if err != nil {
+		return 0, 0, err
+	}
+	if per < 1 || per > apiMaxPerPage {
+		return 0, 0, newHTTPError(http.StatusBadRequest, "per must be between 1 and %d", apiMaxPerPage)
+	}
+
+	return page, per, nil
+}
+
+// paginate returns a page of a list with total items, and the range of items on the page.
+// A page past the end of the list is empty.
+func paginate(total, page, per int) (apiPage, int, int) {
+	p := apiPage{
+		Page: page,
+		PerPage: per,
+		Total: total,
+		Pages: (total + per - 1) / per,
+	}
+	if p.Pages == 0 {
+		p.Pages = 1
+	}
+
+	start := (page - 1) * per
+	if start > total {
+		start = total
+	}
+	end := start + per
+	if end > total {
+		end = total
+	}
+
+	return p, start, end
+}
+
+// apiRPCClient returns the RPC node chosen with the node query parameter, and its id.
+// Without the parameter, an RPC node is picked at random like the dashboard pages do.
+func apiRPCClient(r *http.Request) (int, *rpcclient.Client, error) {
+	if len(clients) == 0 {
+		return 0, nil, newHTTPError(http.StatusServiceUnavailable, "no rpc nodes are connected")
+	}
+
+	if len(r.URL.Query().Get("node")) == 0 {
+		id, err := rand.RandInt(len(clients))
+		if err != nil {
+			return 0, nil, err
+		}
+		return id, clients[id], nil
+	}
+
+	id, err := parseAPIInt(r, "node", 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	c, err := rpcClient(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, c, nil
+}
+
+// apiRPCNodes returns a page of the connected RPC nodes
+func apiRPCNodes(r *http.Request) (interface{}, error) {
+	page, per, err := parsePagination(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p, start, end := paginate(len(clients), page, per)
+	nodes := make([]soterdRPCNode, 0, end - start)
+	// A node that can't be reached is listed with its error, so that the other nodes are still returned
+	var firstErr error
+	failed := 0
+	for id := start; id < end; id++ {
+		n, err := rpcNodeSummary(clients[id])
+		if err != nil {
+			err = rpcError(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			nodes = append(nodes, soterdRPCNode{Id: id, Error: err.Error()})
+			continue
+		}
+		n.Id = id
+		nodes = append(nodes, n)
+	}
+	if len(nodes) > 0 && failed == len(nodes) {
+		return nil, firstErr
+	}
+	p.Data = nodes
+
+	return p, nil
+}
+
+// apiRPCNode returns the RPC node with the id
+func apiRPCNode(id string) (interface{}, error) {
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, newHTTPError(http.StatusBadRequest, "invalid rpc node id '%s'", id)
+	}
+	c, err := rpcClient(i)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := rpcNodeSummary(c)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	n.Id = i
+
+	return n, nil
+}
+
+// apiBlockInfo returns the block with the hash
+func apiBlockInfo(r *http.Request, hash string) (interface{}, error) {
+	_, c, err := apiRPCClient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sb, err := blockInfo(c, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	b := apiBlock{
+		Hash: sb.Header.BlockHash().String(),
+		Version: sb.Header.Version,
+		Parents: make([]string, 0, len(sb.Parents.Parents)),
+		MerkleRoot: sb.MerkleRoot,
+		Timestamp: sb.Header.Timestamp,
+		Bits: strconv.FormatUint(uint64(sb.Header.Bits), 16),
+		Nonce: sb.Header.Nonce,
+		Difficulty: sb.Difficulty,
+		Height: sb.Height,
+		Confirmations: sb.Confirmations,
+		NextHashes: sb.NextHashes,
+		Transactions: make([]string, 0, len(sb.Transactions)),
+		Propagation: sb.Propagation,
+	}
+	for _, p := range sb.Parents.Parents {
+		b.Parents = append(b.Parents, p.Hash.String())
+	}
+	for _, tx := range sb.Transactions {
+		b.Transactions = append(b.Transactions, tx.TxHash().String())
+	}
+
+	return b, nil
+}
+
+// apiHeightInfo returns the hashes of the blocks at the height
+func apiHeightInfo(r *http.Request, height string) (interface{}, error) {
+	h, err := strconv.ParseInt(height, 10, 32)
+	if err != nil || h < 0 {
+		return nil, newHTTPError(http.StatusBadRequest, "invalid height '%s'", height)
+	}
+
+	_, c, err := apiRPCClient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tips, err := c.GetDAGTips()
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	if int32(h) > tips.MaxHeight {
+		return nil, newHTTPError(http.StatusNotFound, "height %d is above the dag's max height %d", h, tips.MaxHeight)
+	}
+
+	hashes, err := c.GetBlockHash(h)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	info := apiHeight{
+		Height: int32(h),
+		Hashes: make([]string, 0, len(hashes)),
+	}
+	for _, hash := range hashes {
+		info.Hashes = append(info.Hashes, hash.String())
+	}
+
+	return info, nil
+}
+
+// apiDagRange returns the blocks between the min and max query parameter heights. By default it returns the recent
+// dag, like the dag page.
+func apiDagRange(r *http.Request) (interface{}, error) {
+	id, c, err := apiRPCClient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tips, err := c.GetDAGTips()
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	maxHeight, err := parseAPIInt(r, "max", int(tips.MaxHeight))
+	if err != nil {
+		return nil, err
+	}
+	minHeight, err := parseAPIInt(r, "min", maxHeight - int(recentDagRange))
+	if err != nil {
+		return nil, err
+	}
+	if minHeight < 0 {
+		minHeight = 0
+	}
+	if maxHeight > int(tips.MaxHeight) {
+		maxHeight = int(tips.MaxHeight)
+	}
+	if minHeight > maxHeight {
+		return nil, newHTTPError(http.StatusBadRequest, "min height %d is above max height %d", minHeight, maxHeight)
+	}
+	if maxHeight - minHeight >= apiMaxDagRange {
+		return nil, newHTTPError(http.StatusBadRequest, "dag range can't span more than %d heights", apiMaxDagRange)
+	}
+
+	coloring, _, err := fetchColoring(c)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	blue := make(map[string]bool)
+	for _, dagNode := range coloring {
+		blue[dagNode.Hash] = dagNode.IsBlue
+	}
+
+	dag := apiDag{
+		RPCNode: id,
+		MinHeight: int32(minHeight),
+		MaxHeight: int32(maxHeight),
+		Blocks: make([]apiDagBlock, 0),
+	}
+	for height := minHeight; height <= maxHeight; height++ {
+		hashes, err := c.GetBlockHash(int64(height))
+		if err != nil {
+			return nil, rpcError(err)
+		}
+
+		for _, hash := range hashes {
+			block, err := c.GetBlock(hash)
+			if err != nil {
+				return nil, rpcError(err)
+			}
+
+			b := apiDagBlock{
+				Hash: hash.String(),
+				Height: int32(height),
+				Parents: make([]string, 0, len(block.Parents.Parents)),
+				Blue: blue[hash.String()],
+			}
+			for _, p := range block.Parents.Parents {
+				b.Parents = append(b.Parents, p.Hash.String())
+			}
+			dag.Blocks = append(dag.Blocks, b)
+		}
+	}
+
+	return dag, nil
+}
+
+// apiTipsInfo returns an RPC node's dag tips
+func apiTipsInfo(r *http.Request) (interface{}, error) {
+	id, c, err := apiRPCClient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tips, err := c.GetDAGTips()
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	t := apiTips{
+		RPCNode: id,
+		Tips: tips.Tips,
+		VirtualHash: tips.Hash,
+		MinHeight: tips.MinHeight,
+		MaxHeight: tips.MaxHeight,
+		BlkCount: tips.BlkCount,
+	}
+
+	return t, nil
+}
+
+// apiNodes returns a page of the census nodes, filtered and sorted the same way as the node table
+func apiNodes(r *http.Request) (interface{}, error) {
+	page, per, err := parsePagination(r)
+	if err != nil {
+		return nil, err
+	}
+
+	values := r.URL.Query()
+	q := nodeQuery{
+		Text: strings.TrimSpace(values.Get("q")),
+		CIDR: strings.TrimSpace(values.Get("cidr")),
+		Sort: values.Get("sort"),
+		Desc: values.Get("order") == "desc",
+	}
+	if len(q.Sort) == 0 {
+		q.Sort = "address"
+	}
+
+	rows, err := nodeRows(q)
+	if err != nil {
+		return nil, newHTTPError(http.StatusBadRequest, "%s", err)
+	}
+
+	p, start, end := paginate(len(rows), page, per)
+	p.Data = rows[start:end]
+
+	return p, nil
+}
+
+// apiNodeInfo returns the census node with the address
+func apiNodeInfo(address string) (interface{}, error) {
+	info, err := nodeInfo(address)
+	if err != nil {
+		return nil, err
+	}
+
+	n := apiNode{
+		Address: info.Address,
+		Version: info.Version,
+		Online: info.Online,
+		Stale: info.Stale,
+		LastChecked: info.LastChecked,
+		Connections: make([]string, 0, len(info.Connections)),
+		Geo: apiGeo{
+			Country: info.Geo.Country,
+			CountryCode: info.Geo.CountryCode,
+			City: info.Geo.City,
+			ASN: info.Geo.ASN,
+			Organization: info.Geo.Organization,
+		},
+		Vantages: make([]apiVantage, 0, len(info.Vantages)),
+	}
+	for _, c := range info.Connections {
+		n.Connections = append(n.Connections, c.Address)
+	}
+	if info.Latency.IsMeasured() {
+		n.Latency = &apiLatency{
+			Measured: info.Latency.Measured,
+			Connect: info.Latency.Connect,
+			Handshake: info.Latency.Handshake,
+			Ping: info.Latency.Ping,
+		}
+	}
+	for _, v := range info.Vantages {
+		n.Vantages = append(n.Vantages, apiVantage{
+			Agent: v.Agent,
+			Online: v.Online,
+			LastChecked: v.LastChecked,
+			Reported: v.Reported,
+		})
+	}
+
+	return n, nil
+}
+
+// apiNodeGraphInfo returns the census nodes and the connections between them
+func apiNodeGraphInfo() (interface{}, error) {
+	rows, err := nodeRows(nodeQuery{Sort: "address"})
+	if err != nil {
+		return nil, err
+	}
+
+	var edges []census.Edge
+	for edge := range e.Snapshot().Edges {
+		edges = append(edges, edge)
+	}
+	census.SortEdges(edges)
+
+	g := apiNodeGraph{
+		Nodes: rows,
+		Edges: make([]apiEdge, 0, len(edges)),
+	}
+	for _, edge := range edges {
+		g.Edges = append(g.Edges, apiEdge{A: edge.A, B: edge.B})
+	}
+
+	return g, nil
+}
+
+// handleAPI responds to requests for /api/v1/
+// It returns dashboard resources as JSON, as described by the OpenAPI document at /api/v1/openapi.json.
+func handleAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		renderAPIErr(w, newHTTPError(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+		return
+	}
+
+	// For r.URL.Path of /api/v1/blocks/09d41fa, parts will be: ["blocks", "09d41fa"]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+
+	var data interface{}
+	var err error
+	switch {
+	case len(parts) == 1 && parts[0] == "openapi.json":
+		http.ServeFile(w, r, apiSpecPath)
+		return
+	case len(parts) == 1 && parts[0] == "rpcnodes":
+		data, err = apiRPCNodes(r)
+	case len(parts) == 2 && parts[0] == "rpcnodes":
+		data, err = apiRPCNode(parts[1])
+	case len(parts) == 2 && parts[0] == "blocks":
+		data, err = apiBlockInfo(r, parts[1])
+	case len(parts) == 2 && parts[0] == "heights":
+		data, err = apiHeightInfo(r, parts[1])
+	case len(parts) == 1 && parts[0] == "dag":
+		data, err = apiDagRange(r)
+	case len(parts) == 1 && parts[0] == "tips":
+		data, err = apiTipsInfo(r)
+	case len(parts) == 1 && parts[0] == "nodes":
+		data, err = apiNodes(r)
+	case len(parts) == 2 && parts[0] == "nodes":
+		data, err = apiNodeInfo(parts[1])
+	case len(parts) == 1 && parts[0] == "nodegraph":
+		data, err = apiNodeGraphInfo()
+	default:
+		err = newHTTPError(http.StatusNotFound, "no api resource at %s", r.URL.Path)
+	}
+
+	if err != nil {
+		renderAPIErr(w, err)
+		return
+	}
+	renderAPI(w, http.StatusOK, data)
+}
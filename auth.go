@@ -0,0 +1,408 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soteria-dag/soterd/soterutil"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// The realm shown by browsers when asking for credentials
+	authRealm = "soterdash"
+
+	// How long a generated self-signed certificate is valid for
+	selfSignedValidity = time.Hour * 24 * 365 * 10
+
+	// How long a successful basic auth check is remembered for. Comparing a password with its bcrypt hash takes tens of
+	// milliseconds of CPU, which would otherwise be spent on every request, like the peer table's polling.
+	authCacheTTL = time.Minute * 5
+)
+
+// role is what an authenticated user is allowed to do
+type role int
+
+const (
+	// Viewers can see every page and API resource
+	roleViewer role = iota
+	// Admins can also use endpoints that change state, like the /admin pages
+	roleAdmin
+)
+
+// parseRole returns the role with the name. An empty name is a viewer.
+func parseRole(name string) (role, error) {
+	switch name {
+	case "", "viewer":
+		return roleViewer, nil
+	case "admin":
+		return roleAdmin, nil
+	default:
+		return roleViewer, fmt.Errorf("unknown role '%s' (use viewer or admin)", name)
+	}
+}
+
+func (r role) String() string {
+	if r == roleAdmin {
+		return "admin"
+	}
+	return "viewer"
+}
+
+// authUser is a user that a request was authenticated as
+type authUser struct {
+	Name string
+	Role role
+}
+
+// authenticator checks a request's credentials
+type authenticator interface {
+	// authenticate returns the user the request has credentials for. It returns false if the request doesn't have
+	// credentials of this authenticator's kind, and an error if it has credentials that aren't valid.
+	authenticate(r *http.Request) (authUser, bool, error)
+
+	// challenge sets the response headers that tell a client how to authenticate
+	challenge(w http.ResponseWriter)
+}
+
+// basicAuth authenticates HTTP basic credentials against bcrypt password hashes
+type basicAuth struct {
+	// Maps user name to the user's password hash and role
+	hashes map[string][]byte
+	roles map[string]role
+
+	// Maps a keyed hash of credentials that passed the bcrypt comparison to when they stop being trusted without it.
+	// The key is random for each process, so the cache can't be used to guess passwords offline.
+	cacheKey []byte
+	cache map[[sha256.Size]byte]time.Time
+	cacheLock sync.Mutex
+}
+
+// newBasicAuth returns a basicAuth for the configured users
+func newBasicAuth(users []authUserConfig) (*basicAuth, error) {
+	a := basicAuth{
+		hashes: make(map[string][]byte),
+		roles: make(map[string]role),
+		cacheKey: make([]byte, sha256.Size),
+		cache: make(map[[sha256.Size]byte]time.Time),
+	}
+	if _, err := rand.Read(a.cacheKey); err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		if len(u.Name) == 0 {
+			return nil, fmt.Errorf("user has no name")
+		}
+		if _, exists := a.hashes[u.Name]; exists {
+			return nil, fmt.Errorf("user %s is configured more than once", u.Name)
+		}
+		if _, err := bcrypt.Cost([]byte(u.Hash)); err != nil {
+			return nil, fmt.Errorf("user %s doesn't have a valid bcrypt hash: %s", u.Name, err)
+		}
+		r, err := parseRole(u.Role)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %s", u.Name, err)
+		}
+
+		a.hashes[u.Name] = []byte(u.Hash)
+		a.roles[u.Name] = r
+	}
+
+	return &a, nil
+}
+
+func (a *basicAuth) authenticate(r *http.Request) (authUser, bool, error) {
+	name, pass, ok := r.BasicAuth()
+	if !ok {
+		return authUser{}, false, nil
+	}
+
+	key := a.credentialKey(name, pass)
+	if a.cached(key) {
+		return authUser{Name: name, Role: a.roles[name]}, true, nil
+	}
+
+	hash, exists := a.hashes[name]
+	if !exists {
+		// Compare anyway, so that unknown users take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+		return authUser{}, true, fmt.Errorf("invalid user name or password")
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil {
+		return authUser{}, true, fmt.Errorf("invalid user name or password")
+	}
+	a.remember(key)
+
+	return authUser{Name: name, Role: a.roles[name]}, true, nil
+}
+
+// credentialKey returns the key of the user name and password in the cache of successful checks
+func (a *basicAuth) credentialKey(name, pass string) [sha256.Size]byte {
+	var key [sha256.Size]byte
+	mac := hmac.New(sha256.New, a.cacheKey)
+	mac.Write([]byte(name + ":" + pass))
+	copy(key[:], mac.Sum(nil))
+	return key
+}
+
+// cached returns true if the credentials with the key passed a check within authCacheTTL
+func (a *basicAuth) cached(key [sha256.Size]byte) bool {
+	a.cacheLock.Lock()
+	defer a.cacheLock.Unlock()
+
+	expires, exists := a.cache[key]
+	return exists && time.Now().Before(expires)
+}
+
+// remember caches the credentials with the key as valid for authCacheTTL, and discards expired entries
+func (a *basicAuth) remember(key [sha256.Size]byte) {
+	a.cacheLock.Lock()
+	defer a.cacheLock.Unlock()
+
+	now := time.Now()
+	for k, expires := range a.cache {
+		if !now.Before(expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[key] = now.Add(authCacheTTL)
+}
+
+func (a *basicAuth) challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+}
+
+// A bcrypt hash that unknown users' passwords are compared with
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("soterdash"), bcrypt.DefaultCost)
+
+// tokenAuth authenticates static bearer tokens, for API clients
+type tokenAuth struct {
+	tokens []authTokenConfig
+	roles []role
+}
+
+// newTokenAuth returns a tokenAuth for the configured tokens
+func newTokenAuth(tokens []authTokenConfig) (*tokenAuth, error) {
+	var a tokenAuth
+	for _, t := range tokens {
+		if len(t.Token) == 0 {
+			return nil, fmt.Errorf("token %s is empty", t.Name)
+		}
+		r, err := parseRole(t.Role)
+		if err != nil {
+			return nil, fmt.Errorf("token %s: %s", t.Name, err)
+		}
+
+		a.tokens = append(a.tokens, t)
+		a.roles = append(a.roles, r)
+	}
+
+	return &a, nil
+}
+
+func (a *tokenAuth) authenticate(r *http.Request) (authUser, bool, error) {
+	// Tokens are only for API clients
+	if !isAPIRequest(r) {
+		return authUser{}, false, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return authUser{}, false, nil
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	for i, t := range a.tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t.Token)) == 1 {
+			return authUser{Name: t.Name, Role: a.roles[i]}, true, nil
+		}
+	}
+
+	return authUser{}, true, fmt.Errorf("invalid bearer token")
+}
+
+func (a *tokenAuth) challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", authRealm))
+}
+
+// authHandler requires requests to be authenticated before passing them to the next handler.
+// Requests that change state, and requests for the admin pages, also require the admin role.
+type authHandler struct {
+	next http.Handler
+	authenticators []authenticator
+}
+
+// newAuthHandler returns a handler that authenticates requests with the configured users and tokens.
+// If none are configured, requests are passed to the next handler without authentication.
+func newAuthHandler(next http.Handler, cfg authConfig) (http.Handler, error) {
+	var authenticators []authenticator
+
+	if len(cfg.Users) > 0 {
+		a, err := newBasicAuth(cfg.Users)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+
+	if len(cfg.Tokens) > 0 {
+		a, err := newTokenAuth(cfg.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+
+	if len(authenticators) == 0 {
+		return next, nil
+	}
+
+	return &authHandler{next: next, authenticators: authenticators}, nil
+}
+
+// needsAdmin returns true if the request needs the admin role
+func needsAdmin(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		return true
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// isAPIRequest returns true if the request is for a machine-readable resource, which shouldn't get an HTML error page
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiPrefix) || r.URL.Path == "/events" || r.URL.Path == "/metrics"
+}
+
+// deny responds that the request isn't allowed, as JSON for API requests or an error page otherwise
+func (h *authHandler) deny(w http.ResponseWriter, r *http.Request, err *httpError) {
+	if err.Status == http.StatusUnauthorized {
+		for _, a := range h.authenticators {
+			a.challenge(w)
+		}
+	}
+
+	if isAPIRequest(r) {
+		renderAPIErr(w, err)
+	} else {
+		renderHTMLErr(w, err)
+	}
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Census agents authenticate their reports with the federation token instead
+	if r.URL.Path == "/federation/report" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	var user authUser
+	authenticated := false
+	for _, a := range h.authenticators {
+		u, found, err := a.authenticate(r)
+		if !found {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed authentication for %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			h.deny(w, r, newHTTPError(http.StatusUnauthorized, "%s", err))
+			return
+		}
+
+		user = u
+		authenticated = true
+		break
+	}
+
+	if !authenticated {
+		h.deny(w, r, newHTTPError(http.StatusUnauthorized, "authentication is required"))
+		return
+	}
+
+	if needsAdmin(r) && user.Role != roleAdmin {
+		h.deny(w, r, newHTTPError(http.StatusForbidden, "%s needs the admin role", r.URL.Path))
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// hashPassword reads a password from the first line of r, and writes its bcrypt hash to w
+func hashPassword(r io.Reader, w io.Writer) error {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	pass := strings.TrimRight(line, "\r\n")
+	if len(pass) == 0 {
+		return fmt.Errorf("password is empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(hash))
+	return err
+}
+
+// ensureSelfSignedCert generates a self-signed certificate and key at the paths, if they don't exist already
+func ensureSelfSignedCert(certPath, keyPath string) error {
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+	if certErr == nil || keyErr == nil {
+		return fmt.Errorf("only one of %s and %s exists; remove it to generate a new certificate", certPath, keyPath)
+	}
+
+	log.Printf("Generating self-signed TLS certificate %s", certPath)
+	cert, key, err := soterutil.NewTLSCertPair("soterdash autogenerated cert", time.Now().Add(selfSignedValidity), nil)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{certPath, keyPath} {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ioutil.WriteFile(certPath, cert, 0644)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(keyPath, key, 0600)
+	if err != nil {
+		os.Remove(certPath)
+		return err
+	}
+
+	return nil
+}
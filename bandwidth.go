@@ -0,0 +1,263 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How many of the busiest peers are charted for each RPC node
+	topTalkerCount = 5
+)
+
+// The byte counters of a peer connection
+type peerBytes struct {
+	Sent uint64
+	Recv uint64
+}
+
+// A sample of an RPC node's network traffic counters
+type bandwidthSample struct {
+	Time time.Time
+	// Totals for the node, from getnettotals
+	BytesSent uint64
+	BytesRecv uint64
+	// Maps peer address to the peer connection's counters, from getpeerinfo
+	Peers map[string]peerBytes
+}
+
+// The rate of traffic between two samples, in bytes per second
+type bandwidthRate struct {
+	Time time.Time
+	Sent float64
+	Recv float64
+	// Maps peer address to the combined sent and received rate of the peer connection
+	Peers map[string]float64
+}
+
+// A peer's share of an RPC node's traffic over the bandwidth history
+type talker struct {
+	Addr string
+	Sent uint64
+	Recv uint64
+}
+
+// Total returns the bytes sent and received with the peer
+func (t talker) Total() uint64 {
+	return t.Sent + t.Recv
+}
+
+// bandwidthHistory keeps a rolling history of samples for each RPC node
+type bandwidthHistory struct {
+	// Maps RPC node id to its samples, oldest first
+	samples map[int][]bandwidthSample
+
+	// How long samples are kept for
+	retention time.Duration
+
+	lock sync.RWMutex
+}
+
+// newBandwidthHistory returns a bandwidthHistory that keeps samples for the retention period
+func newBandwidthHistory(retention time.Duration) *bandwidthHistory {
+	return &bandwidthHistory{
+		samples: make(map[int][]bandwidthSample),
+		retention: retention,
+	}
+}
+
+// add appends the sample to the RPC node's history, and discards samples older than the retention period
+func (h *bandwidthHistory) add(id int, s bandwidthSample) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	samples := append(h.samples[id], s)
+	cutoff := s.Time.Add(-h.retention)
+	i := 0
+	for i < len(samples) && samples[i].Time.Before(cutoff) {
+		i++
+	}
+	h.samples[id] = samples[i:]
+}
+
+// get returns the RPC node's samples, oldest first
+func (h *bandwidthHistory) get(id int) []bandwidthSample {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return append([]bandwidthSample{}, h.samples[id]...)
+}
+
+// counterDelta returns how much a counter grew between samples. A counter that went backwards was reset, for example
+// by the node restarting or a peer reconnecting, so its new value is all we know to have been counted since.
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// bandwidthRates returns the traffic rates between each pair of consecutive samples
+func bandwidthRates(samples []bandwidthSample) []bandwidthRate {
+	var rates []bandwidthRate
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i - 1], samples[i]
+		secs := cur.Time.Sub(prev.Time).Seconds()
+		if secs <= 0 {
+			continue
+		}
+
+		r := bandwidthRate{
+			Time: cur.Time,
+			Sent: float64(counterDelta(prev.BytesSent, cur.BytesSent)) / secs,
+			Recv: float64(counterDelta(prev.BytesRecv, cur.BytesRecv)) / secs,
+			Peers: make(map[string]float64),
+		}
+		for addr, pb := range cur.Peers {
+			before := prev.Peers[addr]
+			r.Peers[addr] = float64(counterDelta(before.Sent, pb.Sent) + counterDelta(before.Recv, pb.Recv)) / secs
+		}
+
+		rates = append(rates, r)
+	}
+
+	return rates
+}
+
+// topTalkers returns the peers that sent and received the most bytes over the samples, busiest first
+func topTalkers(samples []bandwidthSample, count int) []talker {
+	totals := make(map[string]*talker)
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i - 1], samples[i]
+		for addr, pb := range cur.Peers {
+			before := prev.Peers[addr]
+			t, exists := totals[addr]
+			if !exists {
+				t = &talker{Addr: addr}
+				totals[addr] = t
+			}
+			t.Sent += counterDelta(before.Sent, pb.Sent)
+			t.Recv += counterDelta(before.Recv, pb.Recv)
+		}
+	}
+
+	var talkers []talker
+	for _, t := range totals {
+		if t.Total() > 0 {
+			talkers = append(talkers, *t)
+		}
+	}
+
+	sort.Slice(talkers, func(i, j int) bool {
+		if talkers[i].Total() == talkers[j].Total() {
+			return talkers[i].Addr < talkers[j].Addr
+		}
+		return talkers[i].Total() > talkers[j].Total()
+	})
+
+	if len(talkers) > count {
+		talkers = talkers[:count]
+	}
+
+	return talkers
+}
+
+// bandwidthPoller samples the network traffic counters of the RPC nodes on an interval
+type bandwidthPoller struct {
+	history *bandwidthHistory
+	interval time.Duration
+
+	started int32
+	shutdown int32
+	wg sync.WaitGroup
+	quit chan struct{}
+}
+
+// newBandwidthPoller returns a bandwidthPoller that adds samples to the history
+func newBandwidthPoller(history *bandwidthHistory, interval time.Duration) *bandwidthPoller {
+	return &bandwidthPoller{
+		history: history,
+		interval: interval,
+		quit: make(chan struct{}),
+	}
+}
+
+// sample adds a sample of each RPC node's traffic counters to the history
+func (p *bandwidthPoller) sample() {
+	for id, c := range clients {
+		totals, err := c.GetNetTotals()
+		if err != nil {
+			log.Printf("Failed to get network totals of rpc node %d: %s", id, err)
+			continue
+		}
+
+		peers, err := c.GetPeerInfo()
+		if err != nil {
+			log.Printf("Failed to get peer info of rpc node %d: %s", id, err)
+			continue
+		}
+
+		// Prefer the node's own timestamp, which matches when its counters were read
+		sampled := time.Now()
+		if totals.TimeMillis > 0 {
+			sampled = time.Unix(0, totals.TimeMillis * int64(time.Millisecond))
+		}
+
+		s := bandwidthSample{
+			Time: sampled,
+			BytesSent: totals.TotalBytesSent,
+			BytesRecv: totals.TotalBytesRecv,
+			Peers: make(map[string]peerBytes),
+		}
+		for _, peer := range peers {
+			s.Peers[peer.Addr] = peerBytes{Sent: peer.BytesSent, Recv: peer.BytesRecv}
+		}
+
+		p.history.add(id, s)
+	}
+}
+
+// run samples the RPC nodes until the poller is stopped
+func (p *bandwidthPoller) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.sample()
+	for {
+		select {
+			case <-ticker.C:
+				p.sample()
+			case <-p.quit:
+				return
+		}
+	}
+}
+
+// Start sampling in a new goroutine
+func (p *bandwidthPoller) Start() {
+	if atomic.AddInt32(&p.started, 1) != 1 {
+		return
+	}
+
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop sampling
+func (p *bandwidthPoller) Stop() {
+	if atomic.AddInt32(&p.shutdown, 1) != 1 {
+		return
+	}
+
+	close(p.quit)
+	p.wg.Wait()
+}
@@ -0,0 +1,293 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package census
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// Address classes that can be used in address filter rules
+	// Matches every address
+	ClassAll = "all"
+	// Matches RFC1918, RFC4193 (unique local) and RFC6598 (carrier-grade NAT) addresses
+	ClassPrivate = "private"
+	// Matches loopback addresses and localhost
+	ClassLoopback = "loopback"
+	// Matches tor hidden service addresses
+	ClassOnion = "onion"
+	// Matches addresses that can't be reached over the internet (unspecified, link-local, multicast, documentation, reserved)
+	ClassUnroutable = "unroutable"
+)
+
+var (
+	privateBlocks = mustParseCIDRs(
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"100.64.0.0/10",
+		"fc00::/7",
+	)
+
+	unroutableBlocks = mustParseCIDRs(
+		"0.0.0.0/8",
+		"169.254.0.0/16",
+		"192.0.2.0/24",
+		"198.51.100.0/24",
+		"203.0.113.0/24",
+		"224.0.0.0/4",
+		"240.0.0.0/4",
+		"::/128",
+		"fe80::/10",
+		"ff00::/8",
+		"2001:db8::/32",
+	)
+)
+
+// mustParseCIDRs returns the networks for the CIDR strings, and panics if one can't be parsed
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var blocks []*net.IPNet
+	for _, c := range cidrs {
+		_, block, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+// inBlocks returns true if the ip is within any of the networks
+func inBlocks(ip net.IP, blocks []*net.IPNet) bool {
+	for _, b := range blocks {
+		if b.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NormalizeAddress returns the canonical host:port form of the address, so that different spellings of the same
+// address are treated as the same node.
+//
+// The default port is used when the address doesn't have one. Hosts are lowercased, localhost and IPv4-mapped IPv6
+// addresses become their IPv4 form, the IPv6 loopback address becomes 127.0.0.1, and other IPv6 addresses are
+// compressed and wrapped in brackets.
+func NormalizeAddress(address, defaultPort string) (string, error) {
+	address = strings.TrimSpace(address)
+	if len(address) == 0 {
+		return "", fmt.Errorf("empty address")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// The address may not have a port. A bare IPv6 address has colons in it, with or without brackets.
+		host = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+		if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+			return "", fmt.Errorf("invalid address %s: %s", address, err)
+		}
+		port = defaultPort
+	}
+
+	if len(host) == 0 {
+		return "", fmt.Errorf("invalid address %s: missing host", address)
+	}
+	if len(port) == 0 {
+		return "", fmt.Errorf("invalid address %s: missing port", address)
+	}
+
+	host = strings.ToLower(host)
+	if host == "localhost" {
+		host = "127.0.0.1"
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.Equal(net.IPv6loopback) {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+
+		if v4 := ip.To4(); v4 != nil {
+			host = v4.String()
+		} else {
+			host = ip.String()
+		}
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// addressClasses returns the address classes that the host:port address belongs to
+func addressClasses(address string) []string {
+	classes := []string{ClassAll}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	if strings.HasSuffix(host, ".onion") {
+		return append(classes, ClassOnion)
+	}
+	if host == "localhost" {
+		return append(classes, ClassLoopback)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return classes
+	}
+
+	if ip.IsLoopback() {
+		classes = append(classes, ClassLoopback)
+	}
+	if inBlocks(ip, privateBlocks) {
+		classes = append(classes, ClassPrivate)
+	}
+	if inBlocks(ip, unroutableBlocks) {
+		classes = append(classes, ClassUnroutable)
+	}
+
+	return classes
+}
+
+// isOnion returns true if the host:port address is a tor hidden service address
+func isOnion(address string) bool {
+	for _, c := range addressClasses(address) {
+		if c == ClassOnion {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addressRule matches addresses by either class or CIDR block
+type addressRule struct {
+	class string
+	block *net.IPNet
+}
+
+// parseAddressRule returns the rule for a class name or CIDR string
+func parseAddressRule(s string) (addressRule, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case ClassAll, ClassPrivate, ClassLoopback, ClassOnion, ClassUnroutable:
+		return addressRule{class: s}, nil
+	}
+
+	_, block, err := net.ParseCIDR(s)
+	if err != nil {
+		return addressRule{}, fmt.Errorf("invalid address rule '%s': must be a CIDR block or one of %s, %s, %s, %s, %s",
+			s, ClassAll, ClassPrivate, ClassLoopback, ClassOnion, ClassUnroutable)
+	}
+
+	return addressRule{block: block}, nil
+}
+
+// matches returns true if the host:port address matches the rule
+func (r addressRule) matches(address string) bool {
+	if r.block == nil {
+		for _, c := range addressClasses(address) {
+			if c == r.class {
+				return true
+			}
+		}
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && r.block.Contains(ip)
+}
+
+// String returns the rule as it would be written in a filter list
+func (r addressRule) String() string {
+	if r.block == nil {
+		return r.class
+	}
+	return r.block.String()
+}
+
+// AddressFilter decides which addresses learned from the p2p network are added to the census.
+//
+// An address that matches an allow rule is always accepted. Otherwise it's rejected if it matches a deny rule.
+// Addresses that match neither list are accepted.
+type AddressFilter struct {
+	allow []addressRule
+	deny []addressRule
+}
+
+// ParseAddressFilter returns an AddressFilter for comma-separated lists of allow and deny rules.
+// Each rule is a CIDR block, or an address class (all, private, loopback, onion, unroutable).
+func ParseAddressFilter(allow, deny string) (*AddressFilter, error) {
+	var f AddressFilter
+
+	for _, list := range []struct {
+		rules string
+		dst *[]addressRule
+	}{
+		{allow, &f.allow},
+		{deny, &f.deny},
+	} {
+		for _, s := range strings.Split(list.rules, ",") {
+			if len(strings.TrimSpace(s)) == 0 {
+				continue
+			}
+
+			r, err := parseAddressRule(s)
+			if err != nil {
+				return nil, err
+			}
+			*list.dst = append(*list.dst, r)
+		}
+	}
+
+	return &f, nil
+}
+
+// Allows returns true if the normalized host:port address should be added to the census
+func (f *AddressFilter) Allows(address string) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, r := range f.allow {
+		if r.matches(address) {
+			return true
+		}
+	}
+
+	for _, r := range f.deny {
+		if r.matches(address) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String returns a description of the filter's rules
+func (f *AddressFilter) String() string {
+	if f == nil {
+		return "allow all"
+	}
+
+	join := func(rules []addressRule) string {
+		var s []string
+		for _, r := range rules {
+			s = append(s, r.String())
+		}
+		return strings.Join(s, ",")
+	}
+
+	return fmt.Sprintf("allow %s, deny %s", join(f.allow), join(f.deny))
+}
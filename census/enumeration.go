@@ -5,6 +5,7 @@
 package census
 
 import (
+	"fmt"
 	"log"
 	"sync"
 	"sync/atomic"
@@ -23,6 +24,16 @@ const (
 var (
 	// How long workers should sleep between taking actions (like checking nodes)
 	workerWait = time.Second * 5
+
+	// How often the enumerator reconciles its worker pool (restarts, autoscaling)
+	scaleInterval = time.Second * 10
+
+	// The initial and maximum delay before restarting a worker that exited unexpectedly
+	minRestartBackoff = time.Second * 5
+	maxRestartBackoff = time.Minute * 5
+
+	// How many overdue nodes a single worker is expected to keep up with, when autoscaling
+	overduePerWorker = 10
 )
 
 // Enumerator is used to collect information from soterd nodes participating in the p2p network.
@@ -41,12 +52,58 @@ type Enumerator struct {
 	// The interval that we'll poll each node at
 	Interval time.Duration
 
+	// The workers that are currently running, keyed by worker number
+	workers map[int]*Worker
+
+	// Workers that exited unexpectedly and are waiting to be restarted, keyed by worker number
+	restarts map[int]*restart
+
+	// A lock to prevent concurrent changes to the worker pool
+	workersLock sync.Mutex
+
 	// How many workers we should use for enumeration
+	targetWorkers int
+
+	// The bounds that autoscaling keeps targetWorkers within
+	minWorkers int
 	maxWorkers int
 
+	// If the number of workers should follow the number of overdue nodes
+	autoscale bool
+
+	// How many times workers have exited unexpectedly
+	workerFailures int
+
+	// The number that will be given to the next new worker
+	nextWorkerNum int
+
 	// Which p2p network to use, when connecting to soterd nodes
 	soterdNet *chaincfg.Params
 
+	// Decides which addresses learned from the p2p network are added to the census.
+	// Seed nodes are always added.
+	filter *AddressFilter
+
+	// Maps the normalized addresses of the seed nodes to where the seed came from
+	seedAddrs map[string]string
+
+	// Used to look up where nodes are located when they're added to the census
+	geo *GeoDB
+
+	// Delivers census events to subscribers
+	events *eventBus
+
+	// How often a snapshot of the census is taken, and the snapshots taken so far
+	snapshotInterval time.Duration
+	snapshots *snapshotHistory
+
+	// Durations and outcomes of the node polls made by workers
+	polls *pollRecorder
+
+	// Maps agent name to the status of the census reports it has pushed to us
+	agents map[string]*AgentStatus
+	agentsLock sync.Mutex
+
 	// Help Start and Stop methods to determine if enumeration has already been started/stopped
 	started        int32
 	shutdown       int32
@@ -57,53 +114,268 @@ type Enumerator struct {
 	// Listens on notifications from workers, and logs them on behalf of workers
 	workerNotifications chan string
 
+	// Workers send themselves on this channel when they exit
+	workerExits chan *Worker
+
 	// Listens on quit for a message to shutdown
 	quit	chan struct{}
 }
 
+// restart tracks when a worker that exited unexpectedly should be started again
+type restart struct {
+	// How many times in a row the worker has failed
+	failures int
+
+	// When we should next attempt to start the worker
+	at time.Time
+}
+
+// WorkerStatus describes the state of the enumerator's worker pool
+type WorkerStatus struct {
+	// How many workers are running
+	Running int
+
+	// How many workers are waiting to be restarted
+	Restarting int
+
+	// How many workers the enumerator is trying to keep running
+	Target int
+
+	// The bounds that autoscaling keeps the target within
+	Min int
+	Max int
+
+	// If the target follows the number of overdue nodes
+	Autoscale bool
+
+	// How many nodes are due for polling, but not being checked by a worker
+	Overdue int
+
+	// How many times workers have exited unexpectedly
+	Failures int
+}
+
+// backoff returns how long to wait before restarting a worker that has failed the given number of times in a row
+func backoff(failures int) time.Duration {
+	d := minRestartBackoff
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= maxRestartBackoff {
+			return maxRestartBackoff
+		}
+	}
+
+	return d
+}
+
 // enumeration starts the work of polling nodes in the p2p network.
 func (e *Enumerator) enumeration() {
 	defer e.wg.Done()
 
-	// Launch worker processes
-	log.Println("Starting workers")
-	var err error
-	var workers []*Worker
-	for i := 0; i < e.maxWorkers; i++ {
-		var w *Worker
-		w, err = NewWorker(e, i, workerWait)
-		if err != nil {
-			break
-		}
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
 
-		workers = append(workers, w)
-		e.wg.Add(1)
-		go w.run()
-	}
+	snapshotTicker := time.NewTicker(e.snapshotInterval)
+	defer snapshotTicker.Stop()
 
-	// Abort if there was an error starting workers
-	if err != nil {
-		log.Printf("Failed to start worker: %s", err)
-		for _, w := range workers {
-			close(w.quit)
-		}
-		return
-	}
+	// Launch worker processes
+	log.Println("Starting workers")
+	e.reconcile()
 
 	// Wait for messages
 	for {
 		select {
 			case m := <-e.workerNotifications:
 				log.Println(m)
-			case <-e.quit:
-				for _, w := range workers {
-					close(w.quit)
+			case w := <-e.workerExits:
+				e.workerExited(w)
+			case <-ticker.C:
+				if e.isAutoscaling() {
+					e.scale()
 				}
+				e.reconcile()
+			case <-snapshotTicker.C:
+				e.snapshots.add(takeSnapshot(e.Nodes()))
+			case <-e.quit:
+				e.stopWorkers()
 				return
 		}
 	}
 }
 
+// isAutoscaling returns true if the worker target follows the number of overdue nodes
+func (e *Enumerator) isAutoscaling() bool {
+	e.workersLock.Lock()
+	defer e.workersLock.Unlock()
+
+	return e.autoscale
+}
+
+// overdue returns the number of nodes that are due for polling, but aren't being checked by a worker
+func (e *Enumerator) overdue() int {
+	e.nodesLock.RLock()
+	defer e.nodesLock.RUnlock()
+
+	count := 0
+	for _, n := range e.nodes {
+		if n.IsStale(e.Interval) && !n.isBusy() {
+			count++
+		}
+	}
+
+	return count
+}
+
+// scale moves the worker target one step towards the number of workers needed for the overdue nodes
+func (e *Enumerator) scale() {
+	overdue := e.overdue()
+
+	e.workersLock.Lock()
+	defer e.workersLock.Unlock()
+
+	want := (overdue + overduePerWorker - 1) / overduePerWorker
+	if want < e.minWorkers {
+		want = e.minWorkers
+	}
+	if want > e.maxWorkers {
+		want = e.maxWorkers
+	}
+
+	// Step one worker at a time, so that a brief backlog doesn't start a burst of soterd processes
+	if want > e.targetWorkers {
+		e.targetWorkers++
+		log.Printf("Scaling census workers up to %d (%d nodes overdue)", e.targetWorkers, overdue)
+	} else if want < e.targetWorkers {
+		e.targetWorkers--
+		log.Printf("Scaling census workers down to %d (%d nodes overdue)", e.targetWorkers, overdue)
+	}
+}
+
+// reconcile starts or stops workers until the pool matches the worker target,
+// and restarts workers whose backoff has elapsed.
+func (e *Enumerator) reconcile() {
+	e.workersLock.Lock()
+	defer e.workersLock.Unlock()
+
+	now := time.Now()
+	for num, r := range e.restarts {
+		if now.Before(r.at) {
+			continue
+		}
+
+		delete(e.restarts, num)
+		e.startWorker(num, r.failures)
+	}
+
+	for len(e.workers) + len(e.restarts) < e.targetWorkers {
+		num := e.nextWorkerNum
+		e.nextWorkerNum++
+		e.startWorker(num, 0)
+	}
+
+	for len(e.workers) + len(e.restarts) > e.targetWorkers {
+		if !e.removeWorker() {
+			break
+		}
+	}
+}
+
+// startWorker creates and runs a worker with the given number. If the worker can't be created, it is scheduled for
+// a restart instead, so that the pool doesn't silently shrink.
+//
+// The caller must hold workersLock.
+func (e *Enumerator) startWorker(num, failures int) {
+	w, err := NewWorker(e, num, workerWait)
+	if err != nil {
+		log.Printf("Failed to start worker %d: %s", num, err)
+		e.scheduleRestart(num, failures + 1)
+		return
+	}
+
+	w.failures = failures
+	e.workers[num] = w
+	e.wg.Add(1)
+	go w.run()
+}
+
+// scheduleRestart records that the worker should be started again after a backoff.
+//
+// The caller must hold workersLock.
+func (e *Enumerator) scheduleRestart(num, failures int) {
+	d := backoff(failures)
+	e.workerFailures++
+	log.Printf("Restarting worker %d in %s", num, d)
+	e.restarts[num] = &restart{
+		failures: failures,
+		at: time.Now().Add(d),
+	}
+}
+
+// removeWorker stops one worker, preferring workers waiting for a restart over running ones.
+// It returns false if there was no worker to remove.
+//
+// The caller must hold workersLock.
+func (e *Enumerator) removeWorker() bool {
+	for num := range e.restarts {
+		delete(e.restarts, num)
+		return true
+	}
+
+	// Stop the most recently numbered worker, so that long-running workers are kept
+	victim := -1
+	for num := range e.workers {
+		if num > victim {
+			victim = num
+		}
+	}
+	if victim < 0 {
+		return false
+	}
+
+	w := e.workers[victim]
+	delete(e.workers, victim)
+	w.stop()
+	return true
+}
+
+// workerExited handles a worker that has stopped running
+func (e *Enumerator) workerExited(w *Worker) {
+	e.workersLock.Lock()
+	defer e.workersLock.Unlock()
+
+	if w.isStopping() {
+		// We asked the worker to stop
+		return
+	}
+
+	if e.workers[w.num] != w {
+		return
+	}
+	delete(e.workers, w.num)
+
+	failures := w.failures + 1
+	if w.ranFor() >= maxRestartBackoff {
+		// The worker was healthy for a while, so don't hold earlier failures against it
+		failures = 1
+	}
+	e.scheduleRestart(w.num, failures)
+}
+
+// stopWorkers stops all workers and cancels pending restarts
+func (e *Enumerator) stopWorkers() {
+	e.workersLock.Lock()
+	defer e.workersLock.Unlock()
+
+	for num, w := range e.workers {
+		delete(e.workers, num)
+		w.stop()
+	}
+
+	for num := range e.restarts {
+		delete(e.restarts, num)
+	}
+}
+
 // pickNode returns a node that is available for polling.
 // This method is meant to be called from the context of an enumeration worker goroutine
 func (e *Enumerator) pickNode() (*Node, bool) {
@@ -129,44 +401,235 @@ func (e *Enumerator) pickNode() (*Node, bool) {
 // Use Start() to start taking census from soterd nodes.
 func New(seeds []*Node, interval time.Duration, workers int, net *chaincfg.Params) *Enumerator {
 	e := Enumerator{
-		seeds:               seeds,
 		nodes:               make(map[string]*Node),
 		Interval:            interval,
+		workers:             make(map[int]*Worker),
+		restarts:            make(map[int]*restart),
+		targetWorkers:       workers,
+		minWorkers:          workers,
 		maxWorkers:          workers,
 		soterdNet:           net,
 		workerNotifications: make(chan string),
+		workerExits:         make(chan *Worker),
 		quit:                make(chan struct{}),
+		seedAddrs:           make(map[string]string),
+		events:              newEventBus(),
+		snapshotInterval:    defaultSnapshotInterval,
+		snapshots:           &snapshotHistory{retention: defaultSnapshotRetention},
+		agents:              make(map[string]*AgentStatus),
+		polls:               newPollRecorder(),
+	}
+
+	for _, n := range seeds {
+		err := e.addSeed(n, "startup")
+		if err != nil {
+			log.Printf("Ignoring seed node: %s", err)
+		}
 	}
 
 	return &e
 }
 
-// AddToCensus adds the node to the list of nodes to be polled in enumeration
-func (e *Enumerator) AddToCensus(n *Node) {
+// addSeed adds the node to the seeds, and to the census. Seeds bypass the address filter.
+// It returns an error if the address is invalid or already a seed.
+func (e *Enumerator) addSeed(n *Node, source string) error {
+	addr, err := e.normalize(n.Address)
+	if err != nil {
+		return err
+	}
+
+	e.nodesLock.Lock()
+	defer e.nodesLock.Unlock()
+
+	if _, exists := e.seedAddrs[addr]; exists {
+		return fmt.Errorf("%s is already a seed", addr)
+	}
+
+	n.Address = addr
+	e.seeds = append(e.seeds, n)
+	e.seedAddrs[addr] = source
+
+	// Adding the seed to the census lets workers that have finished checking the initial seeds pick it up
+	if _, exists := e.nodes[addr]; !exists {
+		n.Geo = e.geo.Lookup(addr)
+		e.nodes[addr] = n
+		e.publish(Event{Type: EventDiscovered, Address: addr})
+	}
+
+	return nil
+}
+
+// AddSeed adds the address as a seed node, and to the census so that it's polled. The source describes where the
+// seed came from (seed file, DNS seed, RPC node, admin). Seeds bypass the address filter.
+func (e *Enumerator) AddSeed(address, source string) error {
+	return e.addSeed(&Node{Address: address}, source)
+}
+
+// Seed describes a seed node, and where it came from
+type Seed struct {
+	*Node
+	Source string
+}
+
+// Seeds returns the seed nodes that the census started from, or that were added at runtime
+func (e *Enumerator) Seeds() []Seed {
+	e.nodesLock.RLock()
+	defer e.nodesLock.RUnlock()
+
+	seeds := make([]Seed, 0, len(e.seeds))
+	for _, n := range e.seeds {
+		seeds = append(seeds, Seed{Node: n, Source: e.seedAddrs[n.Address]})
+	}
+
+	return seeds
+}
+
+// seedNodes returns the seed nodes
+func (e *Enumerator) seedNodes() []*Node {
+	e.nodesLock.RLock()
+	defer e.nodesLock.RUnlock()
+
+	nodes := make([]*Node, len(e.seeds))
+	copy(nodes, e.seeds)
+	return nodes
+}
+
+// normalize returns the canonical form of the address, using the p2p network's default port if it doesn't have one
+func (e *Enumerator) normalize(address string) (string, error) {
+	port := ""
+	if e.soterdNet != nil {
+		port = e.soterdNet.DefaultPort
+	}
+
+	return NormalizeAddress(address, port)
+}
+
+// SetAddressFilter changes which addresses learned from the p2p network are added to the census.
+// Nodes already in the census that the filter rejects are removed, unless they're seeds.
+func (e *Enumerator) SetAddressFilter(f *AddressFilter) {
+	e.nodesLock.Lock()
+	defer e.nodesLock.Unlock()
+
+	e.filter = f
+	for addr := range e.nodes {
+		if _, seed := e.seedAddrs[addr]; !seed && !f.Allows(addr) {
+			delete(e.nodes, addr)
+		}
+	}
+}
+
+// SetGeoDB changes the database used to look up where nodes are located, and looks up nodes already in the census
+func (e *Enumerator) SetGeoDB(g *GeoDB) {
+	e.nodesLock.Lock()
+	defer e.nodesLock.Unlock()
+
+	e.geo = g
+	for addr, n := range e.nodes {
+		info := g.Lookup(addr)
+		n.updateLock.Lock()
+		n.Geo = info
+		n.updateLock.Unlock()
+	}
+}
+
+// AddToCensus adds the node to the list of nodes to be polled in enumeration.
+// The node's address is normalized before it's added. It returns false if the address was invalid or rejected by
+// the address filter.
+func (e *Enumerator) AddToCensus(n *Node) bool {
+	addr, err := e.normalize(n.Address)
+	if err != nil {
+		return false
+	}
+
 	e.nodesLock.Lock()
 	defer e.nodesLock.Unlock()
 
-	_, exists := e.nodes[n.Address]
+	if _, seed := e.seedAddrs[addr]; !seed && !e.filter.Allows(addr) {
+		return false
+	}
+
+	_, exists := e.nodes[addr]
 	if !exists {
-		e.nodes[n.Address] = n
+		// Nodes already in the census are read by other goroutines, so only a new node's address is normalized
+		n.Address = addr
+		n.Geo = e.geo.Lookup(addr)
+		e.nodes[addr] = n
+		e.publish(Event{Type: EventDiscovered, Address: addr})
 	}
+
+	return true
+}
+
+// publish delivers the event to subscribers, stamping it with the current time
+func (e *Enumerator) publish(ev Event) {
+	ev.Time = time.Now()
+	e.events.publish(ev)
+}
+
+// SetSnapshots changes how often a snapshot of the census is taken, and how long snapshots are kept.
+// It must be called before Start.
+func (e *Enumerator) SetSnapshots(interval, retention time.Duration) error {
+	if interval <= 0 || retention < interval {
+		return fmt.Errorf("invalid snapshot interval %s and retention %s", interval, retention)
+	}
+
+	e.snapshotInterval = interval
+	e.snapshots.lock.Lock()
+	e.snapshots.retention = retention
+	e.snapshots.lock.Unlock()
+
+	return nil
+}
+
+// Snapshot returns a snapshot of the census as it is now
+func (e *Enumerator) Snapshot() *Snapshot {
+	return takeSnapshot(e.Nodes())
+}
+
+// SnapshotAt returns the latest snapshot taken at or before the time. It returns false if no snapshots have been taken
+// yet, or if the time is before the oldest snapshot kept.
+func (e *Enumerator) SnapshotAt(t time.Time) (*Snapshot, bool) {
+	return e.snapshots.at(t)
+}
+
+// SnapshotSpan returns the times of the oldest and newest snapshots kept, and how many there are
+func (e *Enumerator) SnapshotSpan() (time.Time, time.Time, int) {
+	return e.snapshots.span()
+}
+
+// Subscribe returns a Subscription that receives census events of the given types, or all events if no types are
+// given. The buffer sets how many events can be queued for the subscriber before further events are dropped.
+//
+// Call Close on the subscription when done with it. Subscriptions are closed when the enumerator is stopped.
+func (e *Enumerator) Subscribe(buffer int, types ...EventType) *Subscription {
+	return e.events.subscribe(buffer, types)
 }
 
 // Get returns a *Node whose address matches the string, and a bool of if a match was found
 func (e *Enumerator) Get(a string) (*Node, bool) {
+	addr, err := e.normalize(a)
+	if err != nil {
+		return nil, false
+	}
+
 	e.nodesLock.RLock()
 	defer e.nodesLock.RUnlock()
 
-	n, exists := e.nodes[a]
+	n, exists := e.nodes[addr]
 	return n, exists
 }
 
 // IsInCensus returns true if the node is included in the surveys
 func (e *Enumerator) IsInCensus(n *Node) bool {
+	addr, err := e.normalize(n.Address)
+	if err != nil {
+		return false
+	}
+
 	e.nodesLock.RLock()
 	defer e.nodesLock.RUnlock()
 
-	_, exists := e.nodes[n.Address]
+	_, exists := e.nodes[addr]
 	return exists
 }
 
@@ -183,6 +646,70 @@ func (e *Enumerator) Nodes() []*Node {
 	return nodes
 }
 
+// Autoscale enables or disables scaling the number of workers between min and max, based on how many nodes are
+// overdue for polling. The current worker count is clamped to the new bounds.
+func (e *Enumerator) Autoscale(enable bool, min, max int) error {
+	if min < 0 || max < min {
+		return fmt.Errorf("invalid worker bounds: min %d, max %d", min, max)
+	}
+
+	e.workersLock.Lock()
+	defer e.workersLock.Unlock()
+
+	e.autoscale = enable
+	e.minWorkers = min
+	e.maxWorkers = max
+	if e.targetWorkers < min {
+		e.targetWorkers = min
+	}
+	if e.targetWorkers > max {
+		e.targetWorkers = max
+	}
+
+	return nil
+}
+
+// SetWorkers changes the number of workers used for enumeration.
+// When autoscaling is enabled, the count must be within the autoscaling bounds.
+func (e *Enumerator) SetWorkers(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid worker count %d", n)
+	}
+
+	e.workersLock.Lock()
+	if e.autoscale && (n < e.minWorkers || n > e.maxWorkers) {
+		e.workersLock.Unlock()
+		return fmt.Errorf("worker count %d is outside of autoscaling bounds %d-%d", n, e.minWorkers, e.maxWorkers)
+	}
+	e.targetWorkers = n
+	e.workersLock.Unlock()
+
+	if atomic.LoadInt32(&e.started) == 1 && atomic.LoadInt32(&e.shutdown) == 0 {
+		e.reconcile()
+	}
+
+	return nil
+}
+
+// WorkerStatus returns the state of the worker pool
+func (e *Enumerator) WorkerStatus() WorkerStatus {
+	overdue := e.overdue()
+
+	e.workersLock.Lock()
+	defer e.workersLock.Unlock()
+
+	return WorkerStatus{
+		Running: len(e.workers),
+		Restarting: len(e.restarts),
+		Target: e.targetWorkers,
+		Min: e.minWorkers,
+		Max: e.maxWorkers,
+		Autoscale: e.autoscale,
+		Overdue: overdue,
+		Failures: e.workerFailures,
+	}
+}
+
 // RemoveFromCensus removes the node to the list of nodes to be polled in enumeration
 func (e *Enumerator) RemoveFromCensus(n *Node) {
 	e.nodesLock.Lock()
@@ -211,4 +738,5 @@ func (e *Enumerator) Stop() {
 
 	close(e.quit)
 	e.wg.Wait()
+	e.events.close()
 }
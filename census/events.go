@@ -0,0 +1,175 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package census
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies what happened to a node in the census
+type EventType string
+
+const (
+	// A node was added to the census for the first time
+	EventDiscovered = EventType("discovered")
+	// A node that was online stopped responding
+	EventOffline = EventType("offline")
+	// A node that was offline started responding again
+	EventOnline = EventType("online")
+	// A node's soterd version changed
+	EventVersionChanged = EventType("version")
+)
+
+var (
+	// All event types, in the order they're documented
+	EventTypes = []EventType{EventDiscovered, EventOffline, EventOnline, EventVersionChanged}
+)
+
+// ParseEventType returns the EventType for the string
+func ParseEventType(s string) (EventType, error) {
+	for _, t := range EventTypes {
+		if string(t) == s {
+			return t, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown event type '%s'", s)
+}
+
+// Event describes a change to a node in the census
+type Event struct {
+	Type EventType `json:"type"`
+	Address string `json:"address"`
+	Time time.Time `json:"time"`
+
+	// The node's version, and the version before it changed (for EventVersionChanged)
+	Version string `json:"version,omitempty"`
+	PreviousVersion string `json:"previousVersion,omitempty"`
+}
+
+// String returns a description of the event
+func (ev Event) String() string {
+	if ev.Type == EventVersionChanged {
+		return fmt.Sprintf("%s %s %s -> %s", ev.Address, ev.Type, ev.PreviousVersion, ev.Version)
+	}
+	return fmt.Sprintf("%s %s", ev.Address, ev.Type)
+}
+
+// Subscription receives census events on its channel C, until it's closed.
+//
+// Events are delivered without blocking the census. If the subscriber falls behind and the channel's buffer fills up,
+// further events are dropped until there's room again.
+type Subscription struct {
+	// Events are received on C. It's closed when the subscription is closed, or the enumerator is stopped.
+	C <-chan Event
+
+	// The sending side of C
+	c chan Event
+
+	// The event types this subscription receives. An empty set receives all events.
+	types map[EventType]bool
+
+	// How many events were dropped because the buffer was full
+	dropped uint64
+
+	bus *eventBus
+}
+
+// Dropped returns how many events were dropped because the subscriber wasn't keeping up
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops delivery of events to the subscription, and closes its channel
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// eventBus delivers census events to subscribers
+type eventBus struct {
+	subs map[*Subscription]bool
+
+	// A lock to prevent changes to subs while events are being published
+	lock sync.RWMutex
+
+	// Set when the bus is closed, after which new subscriptions are closed immediately
+	closed bool
+}
+
+// newEventBus returns an eventBus with no subscribers
+func newEventBus() *eventBus {
+	return &eventBus{
+		subs: make(map[*Subscription]bool),
+	}
+}
+
+// subscribe returns a new subscription for the event types, with the given channel buffer size
+func (b *eventBus) subscribe(buffer int, types []EventType) *Subscription {
+	c := make(chan Event, buffer)
+	s := Subscription{
+		C: c,
+		c: c,
+		types: make(map[EventType]bool),
+		bus: b,
+	}
+	for _, t := range types {
+		s.types[t] = true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		close(c)
+		return &s
+	}
+
+	b.subs[&s] = true
+	return &s
+}
+
+// unsubscribe removes the subscription from the bus, and closes its channel
+func (b *eventBus) unsubscribe(s *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.subs[s] {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// publish delivers the event to every subscriber interested in its type
+func (b *eventBus) publish(ev Event) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for s := range b.subs {
+		if len(s.types) > 0 && !s.types[ev.Type] {
+			continue
+		}
+
+		select {
+		case s.c <- ev:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// close closes every subscription, and any made afterwards
+func (b *eventBus) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
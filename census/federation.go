@@ -0,0 +1,289 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package census
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// How long an agent waits for the central soterdash to accept a report
+	reportTimeout = time.Second * 30
+)
+
+// ReportNode is a node's state, as seen by the census agent that reported it
+type ReportNode struct {
+	Address string `json:"address"`
+	Version string `json:"version,omitempty"`
+	Online bool `json:"online"`
+	LastChecked time.Time `json:"lastChecked"`
+	Connections []string `json:"connections,omitempty"`
+}
+
+// Report is the census data that an agent pushes to a central soterdash
+type Report struct {
+	Agent string `json:"agent"`
+	Time time.Time `json:"time"`
+	Nodes []ReportNode `json:"nodes"`
+}
+
+// Vantage is what an agent last reported about a node
+type Vantage struct {
+	Agent string
+	Online bool
+	LastChecked time.Time
+
+	// When the report was received
+	Reported time.Time
+
+	// Who the agent saw the node connected to
+	connections []string
+}
+
+// AgentStatus describes the reports received from an agent
+type AgentStatus struct {
+	Name string
+	LastReport time.Time
+	Reports int
+
+	// How many nodes were in the last report, and how many of them the agent could reach
+	Nodes int
+	Reachable int
+}
+
+// Report returns the nodes in the census that have been checked, for pushing to a central soterdash as the agent
+func (e *Enumerator) Report(agent string) Report {
+	r := Report{
+		Agent: agent,
+		Time: time.Now(),
+	}
+
+	for _, n := range e.Nodes() {
+		n.updateLock.RLock()
+		rn := ReportNode{
+			Address: n.Address,
+			Version: n.Version,
+			Online: n.Online,
+			LastChecked: n.LastChecked,
+		}
+		attempted := n.attempted
+		for _, c := range n.connections {
+			rn.Connections = append(rn.Connections, c.Address)
+		}
+		n.updateLock.RUnlock()
+
+		if !attempted {
+			continue
+		}
+		r.Nodes = append(r.Nodes, rn)
+	}
+
+	return r
+}
+
+// Merge adds the nodes in an agent's report to the census, and records which of them the agent could reach.
+// Nodes are subject to the census address filter, the same as ones found by the workers.
+func (e *Enumerator) Merge(r Report) error {
+	if len(r.Agent) == 0 {
+		return fmt.Errorf("report has no agent name")
+	}
+
+	now := time.Now()
+	reachable := 0
+
+	for _, rn := range r.Nodes {
+		addr, err := e.normalize(rn.Address)
+		if err != nil {
+			log.Printf("ignoring address %s from agent %s: %s", rn.Address, r.Agent, err)
+			continue
+		}
+
+		if !e.AddToCensus(&Node{Address: addr}) {
+			// The address filter excluded the node
+			continue
+		}
+		n, _ := e.Get(addr)
+
+		v := Vantage{
+			Agent: r.Agent,
+			Online: rn.Online,
+			LastChecked: rn.LastChecked,
+			Reported: now,
+		}
+		for _, c := range rn.Connections {
+			caddr, err := e.normalize(c)
+			if err != nil {
+				continue
+			}
+			if e.AddToCensus(&Node{Address: caddr}) {
+				v.connections = append(v.connections, caddr)
+			}
+		}
+
+		if rn.Online {
+			reachable++
+		}
+
+		n.updateLock.Lock()
+		if n.vantages == nil {
+			n.vantages = make(map[string]Vantage)
+		}
+		n.vantages[r.Agent] = v
+		// Fill in a version if our own workers haven't been able to get one
+		if len(n.Version) == 0 {
+			n.Version = rn.Version
+		}
+		n.updateLock.Unlock()
+	}
+
+	e.agentsLock.Lock()
+	defer e.agentsLock.Unlock()
+
+	a, exists := e.agents[r.Agent]
+	if !exists {
+		a = &AgentStatus{Name: r.Agent}
+		e.agents[r.Agent] = a
+	}
+	a.LastReport = now
+	a.Reports++
+	a.Nodes = len(r.Nodes)
+	a.Reachable = reachable
+
+	return nil
+}
+
+// Agents returns the status of the agents that have pushed reports to the census, sorted by name
+func (e *Enumerator) Agents() []AgentStatus {
+	e.agentsLock.Lock()
+	defer e.agentsLock.Unlock()
+
+	var agents []AgentStatus
+	for _, a := range e.agents {
+		agents = append(agents, *a)
+	}
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Name < agents[j].Name
+	})
+
+	return agents
+}
+
+// Agent pushes its enumerator's census to a central soterdash on an interval
+type Agent struct {
+	e *Enumerator
+
+	// The name the agent reports as
+	name string
+
+	// The central soterdash's report URL, and the token used to authenticate with it
+	url string
+	token string
+
+	interval time.Duration
+	client *http.Client
+
+	started int32
+	shutdown int32
+	wg sync.WaitGroup
+	quit chan struct{}
+}
+
+// NewAgent returns an Agent that pushes the enumerator's census to the report URL of a central soterdash
+func NewAgent(e *Enumerator, name, url, token string, interval time.Duration) (*Agent, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("agent name is empty")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid push interval %s", interval)
+	}
+
+	a := Agent{
+		e: e,
+		name: name,
+		url: url,
+		token: token,
+		interval: interval,
+		client: &http.Client{Timeout: reportTimeout},
+		quit: make(chan struct{}),
+	}
+
+	return &a, nil
+}
+
+// push sends a report of the census to the central soterdash
+func (a *Agent) push() error {
+	body, err := json.Marshal(a.e.Report(a.name))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer " + a.token)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", a.url, resp.Status)
+	}
+
+	return nil
+}
+
+// run pushes reports until the agent is stopped
+func (a *Agent) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+			case <-ticker.C:
+				err := a.push()
+				if err != nil {
+					log.Printf("agent %s	failed to push census report: %s", a.name, err)
+				}
+			case <-a.quit:
+				return
+		}
+	}
+}
+
+// Start pushing reports in a new goroutine
+func (a *Agent) Start() {
+	if atomic.AddInt32(&a.started, 1) != 1 {
+		return
+	}
+
+	a.wg.Add(1)
+	go a.run()
+}
+
+// Stop pushing reports
+func (a *Agent) Stop() {
+	if atomic.AddInt32(&a.shutdown, 1) != 1 {
+		return
+	}
+
+	close(a.quit)
+	a.wg.Wait()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package census
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoInfo describes where a node's address is located, and which network it belongs to
+type GeoInfo struct {
+	Country string
+	// ISO 3166-1 alpha-2 country code
+	CountryCode string
+	City string
+
+	// The autonomous system number and organization the address is announced by
+	ASN uint
+	Organization string
+}
+
+// IsLocated returns true if a country was found for the address
+func (g GeoInfo) IsLocated() bool {
+	return len(g.CountryCode) > 0
+}
+
+// HasASN returns true if an autonomous system was found for the address
+func (g GeoInfo) HasASN() bool {
+	return g.ASN != 0
+}
+
+// GeoDB looks up GeoInfo for addresses in local MaxMind-format (mmdb) database files.
+// No online lookups are made.
+type GeoDB struct {
+	// A country or city database
+	location *geoip2.Reader
+
+	// An ASN database
+	asn *geoip2.Reader
+}
+
+// OpenGeoDB opens the location (country or city) and ASN database files. Either path may be empty, in which case
+// that part of the GeoInfo won't be filled in.
+func OpenGeoDB(locationPath, asnPath string) (*GeoDB, error) {
+	var g GeoDB
+	var err error
+
+	if len(locationPath) > 0 {
+		g.location, err = geoip2.Open(locationPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open location database %s: %s", locationPath, err)
+		}
+	}
+
+	if len(asnPath) > 0 {
+		g.asn, err = geoip2.Open(asnPath)
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("failed to open ASN database %s: %s", asnPath, err)
+		}
+	}
+
+	return &g, nil
+}
+
+// Close closes the database files
+func (g *GeoDB) Close() {
+	if g.location != nil {
+		_ = g.location.Close()
+	}
+	if g.asn != nil {
+		_ = g.asn.Close()
+	}
+}
+
+// Lookup returns the GeoInfo for the host:port address.
+// Hostnames, onion addresses and addresses that aren't in the databases return an empty GeoInfo.
+func (g *GeoDB) Lookup(address string) GeoInfo {
+	var info GeoInfo
+	if g == nil {
+		return info
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return info
+	}
+
+	if g.location != nil {
+		// City lookups also work on country databases, leaving the city empty
+		city, err := g.location.City(ip)
+		if err == nil {
+			info.Country = city.Country.Names["en"]
+			info.CountryCode = city.Country.IsoCode
+			info.City = city.City.Names["en"]
+		}
+	}
+
+	if g.asn != nil {
+		asn, err := g.asn.ASN(ip)
+		if err == nil {
+			info.ASN = asn.AutonomousSystemNumber
+			info.Organization = asn.AutonomousSystemOrganization
+		}
+	}
+
+	return info
+}
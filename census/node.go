@@ -5,6 +5,7 @@
 package census
 
 import (
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,6 +26,9 @@ type Node struct {
 	// If the node was responding to requests from us the last time we checked it
 	Online bool
 
+	// Where the node's address is located, from the enumerator's GeoDB
+	Geo GeoInfo
+
 	// Who the node was last known to be connected to.
 	// This is used for extending our census's area, and in graphing the connectivity of the p2p network.
 	connections []*Node
@@ -32,6 +36,16 @@ type Node struct {
 	// When the node was last polled. This is used to help determine when we should next poll the same node.
 	LastChecked time.Time
 
+	// Network timing measurements from the last successful latency probe of the node
+	Latency Latency
+
+	// Maps agent name to what the agent last reported about the node, when the census is federated
+	vantages map[string]Vantage
+
+	// If a worker has attempted to check the node before, successfully or not.
+	// This is used to tell a node coming back online apart from a node being checked for the first time.
+	attempted bool
+
 	// A lock to prevent concurrent updates to various node fields (not the busy field)
 	updateLock sync.RWMutex
 
@@ -50,6 +64,21 @@ func allFresh(nodes []*Node, d time.Duration) bool {
 	return true
 }
 
+// allAttempted returns true if a worker has attempted to check every node at least once
+func allAttempted(nodes []*Node) bool {
+	for _, n := range nodes {
+		n.updateLock.RLock()
+		attempted := n.attempted
+		n.updateLock.RUnlock()
+
+		if !attempted {
+			return false
+		}
+	}
+
+	return true
+}
+
 // isBusy returns true if the node is currently being checked by an enumeration worker
 func (n *Node) isBusy() bool {
 	v := atomic.LoadInt32(&n.busy)
@@ -77,18 +106,48 @@ func (n *Node) free() {
 	atomic.StoreInt32(&n.busy, free)
 }
 
+// Connections returns who the node was last known to be connected to, by our workers or by any census agent
 func (n *Node) Connections() []*Node {
 	n.updateLock.RLock()
 	defer n.updateLock.RUnlock()
 
 	var conns []*Node
-	for _, n := range n.connections {
-		conns = append(conns, n)
+	seen := make(map[string]bool)
+	for _, c := range n.connections {
+		conns = append(conns, c)
+		seen[c.Address] = true
+	}
+
+	for _, v := range n.vantages {
+		for _, addr := range v.connections {
+			if seen[addr] {
+				continue
+			}
+			conns = append(conns, &Node{Address: addr})
+			seen[addr] = true
+		}
 	}
 
 	return conns
 }
 
+// Vantages returns what each census agent last reported about the node, sorted by agent name
+func (n *Node) Vantages() []Vantage {
+	n.updateLock.RLock()
+	defer n.updateLock.RUnlock()
+
+	var vantages []Vantage
+	for _, v := range n.vantages {
+		vantages = append(vantages, v)
+	}
+
+	sort.Slice(vantages, func(i, j int) bool {
+		return vantages[i].Agent < vantages[j].Agent
+	})
+
+	return vantages
+}
+
 // String returns a string representing the node
 func (n *Node) String() string {
 	return n.Address
@@ -0,0 +1,136 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package census
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+var (
+	// How long a latency probe may take, from connecting to receiving the pong
+	probeTimeout = time.Second * 10
+)
+
+// Latency holds network timing measurements for a node, taken from soterdash's host
+type Latency struct {
+	// How long it took to establish a TCP connection to the node
+	Connect time.Duration
+
+	// How long it took from sending our version message, to receiving the node's version and verack messages
+	Handshake time.Duration
+
+	// How long it took from sending a ping message, to receiving the matching pong
+	Ping time.Duration
+
+	// When the measurements were taken. The zero value means the node hasn't been measured.
+	Measured time.Time
+}
+
+// IsMeasured returns true if the latency measurements have been taken
+func (l Latency) IsMeasured() bool {
+	return !l.Measured.IsZero()
+}
+
+// readUntil reads messages from the connection until the match function returns true for one of them
+func readUntil(conn net.Conn, params *chaincfg.Params, match func(wire.Message) bool) error {
+	for {
+		msg, _, err := wire.ReadMessage(conn, wire.ProtocolVersion, params.Net)
+		if err != nil {
+			return err
+		}
+
+		if match(msg) {
+			return nil
+		}
+	}
+}
+
+// probe connects to the soterd node at the address and measures connection, handshake and ping latency
+func probe(address string, params *chaincfg.Params) (Latency, error) {
+	var l Latency
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, probeTimeout)
+	if err != nil {
+		return l, err
+	}
+	defer conn.Close()
+	l.Connect = time.Since(start)
+
+	err = conn.SetDeadline(start.Add(probeTimeout))
+	if err != nil {
+		return l, err
+	}
+
+	tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return l, fmt.Errorf("unexpected local address type %T", conn.LocalAddr())
+	}
+	me := wire.NewNetAddress(tcpAddr, 0)
+
+	tcpAddr, ok = conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return l, fmt.Errorf("unexpected remote address type %T", conn.RemoteAddr())
+	}
+	you := wire.NewNetAddress(tcpAddr, 0)
+
+	nonce, err := wire.RandomUint64()
+	if err != nil {
+		return l, err
+	}
+
+	// Exchange version messages. The node replies with its own version and a verack for ours.
+	version := wire.NewMsgVersion(me, you, nonce, 0, (*[32]byte)(params.GenesisHash))
+	version.DisableRelayTx = true
+	start = time.Now()
+	err = wire.WriteMessage(conn, version, wire.ProtocolVersion, params.Net)
+	if err != nil {
+		return l, err
+	}
+
+	gotVersion, gotVerAck := false, false
+	err = readUntil(conn, params, func(msg wire.Message) bool {
+		switch msg.(type) {
+		case *wire.MsgVersion:
+			gotVersion = true
+		case *wire.MsgVerAck:
+			gotVerAck = true
+		}
+		return gotVersion && gotVerAck
+	})
+	if err != nil {
+		return l, fmt.Errorf("handshake failed: %s", err)
+	}
+	l.Handshake = time.Since(start)
+
+	err = wire.WriteMessage(conn, wire.NewMsgVerAck(), wire.ProtocolVersion, params.Net)
+	if err != nil {
+		return l, err
+	}
+
+	// Ping the node, ignoring any other messages it sends us after the handshake
+	start = time.Now()
+	err = wire.WriteMessage(conn, wire.NewMsgPing(nonce), wire.ProtocolVersion, params.Net)
+	if err != nil {
+		return l, err
+	}
+
+	err = readUntil(conn, params, func(msg wire.Message) bool {
+		pong, ok := msg.(*wire.MsgPong)
+		return ok && pong.Nonce == nonce
+	})
+	if err != nil {
+		return l, fmt.Errorf("ping failed: %s", err)
+	}
+	l.Ping = time.Since(start)
+	l.Measured = time.Now()
+
+	return l, nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package census
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg"
+)
+
+var (
+	// How long to wait for a DNS seed lookup
+	dnsSeedTimeout = time.Second * 10
+)
+
+// ReadSeedFile returns the seed addresses listed in the file, one per line.
+// Blank lines, and anything after a # on a line, are ignored.
+func ReadSeedFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		line = strings.TrimSpace(line)
+		if len(line) > 0 {
+			addrs = append(addrs, line)
+		}
+	}
+
+	return addrs, scanner.Err()
+}
+
+// NewResolver returns a resolver that sends DNS queries to the server at the ip:port address.
+// If the address is empty, the system resolver is returned.
+func NewResolver(address string) *net.Resolver {
+	if len(address) == 0 {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// LookupDNSSeeds resolves the network's DNS seeds, and returns the seed host:port addresses using the network's
+// default port. It also returns an error for each DNS seed that couldn't be resolved.
+func LookupDNSSeeds(params *chaincfg.Params, resolver *net.Resolver) ([]string, []error) {
+	var addrs []string
+	var errs []error
+
+	for _, seed := range params.DNSSeeds {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsSeedTimeout)
+		ips, err := resolver.LookupHost(ctx, seed.Host)
+		cancel()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, params.DefaultPort))
+		}
+	}
+
+	return addrs, errs
+}
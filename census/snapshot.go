@@ -0,0 +1,220 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package census
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// How often the enumerator takes a snapshot of the census, and how long snapshots are kept
+	defaultSnapshotInterval = time.Minute
+	defaultSnapshotRetention = time.Hour * 24
+)
+
+// NodeState is the state of a node at the time a snapshot was taken
+type NodeState struct {
+	Address string
+	Version string
+	Online bool
+}
+
+// Snapshot is an immutable copy of the census at a point in time
+type Snapshot struct {
+	Time time.Time
+
+	// Maps node address to the node's state
+	Nodes map[string]NodeState
+
+	// The connections between nodes in the census
+	Edges map[Edge]bool
+}
+
+// VersionChange describes a node whose version differs between two snapshots
+type VersionChange struct {
+	Address string
+	From string
+	To string
+}
+
+// Diff describes what changed in the census between two snapshots
+type Diff struct {
+	From *Snapshot
+	To *Snapshot
+
+	// Nodes that are only online in the To snapshot, or only online in the From snapshot. Nodes stay in the census
+	// once they're discovered, so a node that goes offline disappears, and one that comes back online appears.
+	Appeared []NodeState
+	Disappeared []NodeState
+
+	// Nodes in both snapshots whose version changed
+	VersionChanged []VersionChange
+
+	// Connections that are only in the To snapshot, or only in the From snapshot. Only online nodes' connections are
+	// recorded in a snapshot, so the connections of a node that goes offline are removed.
+	EdgesAdded []Edge
+	EdgesRemoved []Edge
+}
+
+// takeSnapshot returns a Snapshot of the nodes
+func takeSnapshot(nodes []*Node) *Snapshot {
+	s := Snapshot{
+		Time: time.Now(),
+		Nodes: make(map[string]NodeState),
+		Edges: make(map[Edge]bool),
+	}
+
+	for _, n := range nodes {
+		n.updateLock.RLock()
+		s.Nodes[n.Address] = NodeState{
+			Address: n.Address,
+			Version: n.Version,
+			Online: n.Online,
+		}
+		n.updateLock.RUnlock()
+	}
+
+	for _, n := range nodes {
+		// An offline node's connections are the ones it had when it was last polled, which may be long gone
+		if !s.Nodes[n.Address].Online {
+			continue
+		}
+
+		for _, c := range n.Connections() {
+			if c.Address == n.Address {
+				continue
+			}
+			if _, exists := s.Nodes[c.Address]; !exists {
+				continue
+			}
+			s.Edges[NewEdge(n.Address, c.Address)] = true
+		}
+	}
+
+	return &s
+}
+
+// SortEdges sorts the edges by their addresses
+func SortEdges(edges []Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].A == edges[j].A {
+			return edges[i].B < edges[j].B
+		}
+		return edges[i].A < edges[j].A
+	})
+}
+
+// Compare returns what changed in the census between the two snapshots
+func Compare(from, to *Snapshot) *Diff {
+	d := Diff{
+		From: from,
+		To: to,
+	}
+
+	for addr, n := range to.Nodes {
+		prev, existed := from.Nodes[addr]
+		if n.Online && !(existed && prev.Online) {
+			d.Appeared = append(d.Appeared, n)
+		}
+
+		if existed && len(prev.Version) > 0 && len(n.Version) > 0 && prev.Version != n.Version {
+			d.VersionChanged = append(d.VersionChanged, VersionChange{Address: addr, From: prev.Version, To: n.Version})
+		}
+	}
+
+	for addr, n := range from.Nodes {
+		next, exists := to.Nodes[addr]
+		if n.Online && !(exists && next.Online) {
+			d.Disappeared = append(d.Disappeared, n)
+		}
+	}
+
+	for edge := range to.Edges {
+		if !from.Edges[edge] {
+			d.EdgesAdded = append(d.EdgesAdded, edge)
+		}
+	}
+
+	for edge := range from.Edges {
+		if !to.Edges[edge] {
+			d.EdgesRemoved = append(d.EdgesRemoved, edge)
+		}
+	}
+
+	byAddress := func(nodes []NodeState) {
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].Address < nodes[j].Address
+		})
+	}
+	byAddress(d.Appeared)
+	byAddress(d.Disappeared)
+	sort.Slice(d.VersionChanged, func(i, j int) bool {
+		return d.VersionChanged[i].Address < d.VersionChanged[j].Address
+	})
+	SortEdges(d.EdgesAdded)
+	SortEdges(d.EdgesRemoved)
+
+	return &d
+}
+
+// snapshotHistory keeps the census snapshots taken within the retention period, oldest first
+type snapshotHistory struct {
+	snapshots []*Snapshot
+
+	// How long snapshots are kept for
+	retention time.Duration
+
+	lock sync.RWMutex
+}
+
+// add appends the snapshot to the history, and discards snapshots older than the retention period
+func (h *snapshotHistory) add(s *Snapshot) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.snapshots = append(h.snapshots, s)
+
+	cutoff := s.Time.Add(-h.retention)
+	i := 0
+	for i < len(h.snapshots) && h.snapshots[i].Time.Before(cutoff) {
+		i++
+	}
+	h.snapshots = h.snapshots[i:]
+}
+
+// at returns the latest snapshot taken at or before the time. It returns false if there are no snapshots, or if they
+// were all taken after the time.
+func (h *snapshotHistory) at(t time.Time) (*Snapshot, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if len(h.snapshots) == 0 {
+		return nil, false
+	}
+
+	// Find the first snapshot taken after t
+	i := sort.Search(len(h.snapshots), func(i int) bool {
+		return h.snapshots[i].Time.After(t)
+	})
+	if i == 0 {
+		return nil, false
+	}
+
+	return h.snapshots[i - 1], true
+}
+
+// span returns the times of the oldest and newest snapshots, and how many snapshots there are
+func (h *snapshotHistory) span() (time.Time, time.Time, int) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if len(h.snapshots) == 0 {
+		return time.Time{}, time.Time{}, 0
+	}
+
+	return h.snapshots[0].Time, h.snapshots[len(h.snapshots) - 1].Time, len(h.snapshots)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package census
+
+import (
+	"sync"
+	"time"
+)
+
+// PollBuckets are the upper bounds, in seconds, that poll durations are counted in
+var PollBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// PollStats summarizes the node polls that workers have made since the enumerator was created
+type PollStats struct {
+	// How many polls were made, and how many of them failed
+	Polls uint64
+	Failures uint64
+
+	// The total time spent polling, in seconds
+	Seconds float64
+
+	// Maps each of PollBuckets to how many polls took at most that long
+	Buckets map[float64]uint64
+}
+
+// pollRecorder accumulates PollStats
+type pollRecorder struct {
+	stats PollStats
+	lock sync.Mutex
+}
+
+// newPollRecorder returns an empty pollRecorder
+func newPollRecorder() *pollRecorder {
+	return &pollRecorder{
+		stats: PollStats{Buckets: make(map[float64]uint64)},
+	}
+}
+
+// record adds a poll that took d, and failed if err isn't nil
+func (r *pollRecorder) record(d time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	secs := d.Seconds()
+	r.stats.Polls++
+	if err != nil {
+		r.stats.Failures++
+	}
+	r.stats.Seconds += secs
+	for _, b := range PollBuckets {
+		if secs <= b {
+			r.stats.Buckets[b]++
+		}
+	}
+}
+
+// get returns a copy of the stats
+func (r *pollRecorder) get() PollStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s := r.stats
+	s.Buckets = make(map[float64]uint64)
+	for b, count := range r.stats.Buckets {
+		s.Buckets[b] = count
+	}
+
+	return s
+}
+
+// PollStats returns a summary of the node polls that workers have made
+func (e *Enumerator) PollStats() PollStats {
+	return e.polls.get()
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package census
+
+import (
+	"sort"
+)
+
+// Edge is an undirected connection between two nodes, identified by their addresses.
+// A is always the address that sorts first, so that an edge has a single representation.
+type Edge struct {
+	A string
+	B string
+}
+
+// Topology describes the shape of the p2p network, as seen by the census
+type Topology struct {
+	// Node addresses grouped by connected component, largest component first.
+	// More than one component means the network is partitioned.
+	Components [][]string
+
+	// Maps node address to the index of its component in Components
+	Component map[string]int
+
+	// Maps node address to how many other nodes it's connected to
+	Degree map[string]int
+
+	// Maps a degree to how many nodes have that degree
+	DegreeDistribution map[int]int
+
+	// Nodes whose removal would split their component (single points of failure)
+	ArticulationPoints []string
+
+	// Connections whose removal would split their component
+	Bridges []Edge
+
+	// The longest shortest-path between any two connected nodes, in hops
+	Diameter int
+
+	// Maps node address to its betweenness centrality, normalized to the range 0-1
+	Betweenness map[string]float64
+}
+
+// NewEdge returns the Edge between two addresses
+func NewEdge(a, b string) Edge {
+	if b < a {
+		a, b = b, a
+	}
+
+	return Edge{A: a, B: b}
+}
+
+// adjacency returns the sorted node addresses, and the undirected connections between them.
+// Connections to nodes that aren't in the slice are ignored.
+func adjacency(nodes []*Node) ([]string, map[string][]string) {
+	adj := make(map[string][]string)
+	for _, n := range nodes {
+		adj[n.Address] = nil
+	}
+
+	seen := make(map[Edge]bool)
+	for _, n := range nodes {
+		for _, c := range n.Connections() {
+			if c.Address == n.Address {
+				continue
+			}
+			if _, exists := adj[c.Address]; !exists {
+				continue
+			}
+
+			edge := NewEdge(n.Address, c.Address)
+			if seen[edge] {
+				continue
+			}
+			seen[edge] = true
+
+			adj[edge.A] = append(adj[edge.A], edge.B)
+			adj[edge.B] = append(adj[edge.B], edge.A)
+		}
+	}
+
+	addrs := make([]string, 0, len(adj))
+	for a, peers := range adj {
+		addrs = append(addrs, a)
+		// Sorting neighbours keeps the analysis results stable between calls
+		sort.Strings(peers)
+	}
+	sort.Strings(addrs)
+
+	return addrs, adj
+}
+
+// Analyze returns the Topology of the connections between the nodes
+func Analyze(nodes []*Node) *Topology {
+	addrs, adj := adjacency(nodes)
+
+	t := Topology{
+		Component: make(map[string]int),
+		Degree: make(map[string]int),
+		DegreeDistribution: make(map[int]int),
+		Betweenness: make(map[string]float64),
+	}
+
+	for _, a := range addrs {
+		d := len(adj[a])
+		t.Degree[a] = d
+		t.DegreeDistribution[d]++
+	}
+
+	t.Components = components(addrs, adj)
+	for i, c := range t.Components {
+		for _, a := range c {
+			t.Component[a] = i
+		}
+	}
+
+	t.ArticulationPoints, t.Bridges = cutPoints(addrs, adj)
+	t.Diameter, t.Betweenness = distances(addrs, adj)
+
+	return &t
+}
+
+// IsPartitioned returns true if the network is split into more than one connected component
+func (t *Topology) IsPartitioned() bool {
+	return len(t.Components) > 1
+}
+
+// IsArticulationPoint returns true if removing the node would split its component
+func (t *Topology) IsArticulationPoint(address string) bool {
+	for _, a := range t.ArticulationPoints {
+		if a == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsBridge returns true if removing the connection between the two nodes would split their component
+func (t *Topology) IsBridge(a, b string) bool {
+	edge := NewEdge(a, b)
+	for _, br := range t.Bridges {
+		if br == edge {
+			return true
+		}
+	}
+
+	return false
+}
+
+// components returns the connected components of the graph, largest first
+func components(addrs []string, adj map[string][]string) [][]string {
+	visited := make(map[string]bool)
+	var comps [][]string
+
+	for _, start := range addrs {
+		if visited[start] {
+			continue
+		}
+
+		// Breadth-first search from this node collects everything reachable from it
+		var comp []string
+		queue := []string{start}
+		visited[start] = true
+		for len(queue) > 0 {
+			a := queue[0]
+			queue = queue[1:]
+			comp = append(comp, a)
+
+			for _, p := range adj[a] {
+				if !visited[p] {
+					visited[p] = true
+					queue = append(queue, p)
+				}
+			}
+		}
+
+		sort.Strings(comp)
+		comps = append(comps, comp)
+	}
+
+	sort.SliceStable(comps, func(i, j int) bool {
+		return len(comps[i]) > len(comps[j])
+	})
+
+	return comps
+}
+
+// cutPoints returns the articulation points and bridges of the graph, using Tarjan's algorithm
+func cutPoints(addrs []string, adj map[string][]string) ([]string, []Edge) {
+	// disc tracks the order nodes were discovered in, low tracks the earliest-discovered node reachable from a
+	// node's depth-first subtree using at most one back-edge.
+	disc := make(map[string]int)
+	low := make(map[string]int)
+	isCut := make(map[string]bool)
+	var bridges []Edge
+	order := 0
+
+	var visit func(a, parent string)
+	visit = func(a, parent string) {
+		order++
+		disc[a] = order
+		low[a] = order
+		children := 0
+
+		for _, p := range adj[a] {
+			if p == parent {
+				continue
+			}
+
+			if _, seen := disc[p]; seen {
+				if disc[p] < low[a] {
+					low[a] = disc[p]
+				}
+				continue
+			}
+
+			children++
+			visit(p, a)
+			if low[p] < low[a] {
+				low[a] = low[p]
+			}
+
+			if parent != "" && low[p] >= disc[a] {
+				isCut[a] = true
+			}
+			if low[p] > disc[a] {
+				bridges = append(bridges, NewEdge(a, p))
+			}
+		}
+
+		// The root of a depth-first tree is an articulation point if it has more than one subtree
+		if parent == "" && children > 1 {
+			isCut[a] = true
+		}
+	}
+
+	for _, a := range addrs {
+		if _, seen := disc[a]; !seen {
+			visit(a, "")
+		}
+	}
+
+	var points []string
+	for _, a := range addrs {
+		if isCut[a] {
+			points = append(points, a)
+		}
+	}
+
+	sort.Slice(bridges, func(i, j int) bool {
+		if bridges[i].A == bridges[j].A {
+			return bridges[i].B < bridges[j].B
+		}
+		return bridges[i].A < bridges[j].A
+	})
+
+	return points, bridges
+}
+
+// distances returns the diameter of the graph and the betweenness centrality of each node, using Brandes' algorithm
+func distances(addrs []string, adj map[string][]string) (int, map[string]float64) {
+	diameter := 0
+	centrality := make(map[string]float64)
+	for _, a := range addrs {
+		centrality[a] = 0
+	}
+
+	for _, s := range addrs {
+		// Breadth-first search from s, counting the shortest paths to each node
+		var stack []string
+		preds := make(map[string][]string)
+		paths := map[string]float64{s: 1}
+		dist := map[string]int{s: 0}
+		queue := []string{s}
+
+		for len(queue) > 0 {
+			v := queue[0]
+			queue = queue[1:]
+			stack = append(stack, v)
+
+			if dist[v] > diameter {
+				diameter = dist[v]
+			}
+
+			for _, w := range adj[v] {
+				if _, seen := dist[w]; !seen {
+					dist[w] = dist[v] + 1
+					queue = append(queue, w)
+				}
+				if dist[w] == dist[v] + 1 {
+					paths[w] += paths[v]
+					preds[w] = append(preds[w], v)
+				}
+			}
+		}
+
+		// Walk back from the farthest nodes, accumulating each node's share of the shortest paths through it
+		delta := make(map[string]float64)
+		for i := len(stack) - 1; i >= 0; i-- {
+			w := stack[i]
+			for _, v := range preds[w] {
+				delta[v] += paths[v] / paths[w] * (1 + delta[w])
+			}
+			if w != s {
+				centrality[w] += delta[w]
+			}
+		}
+	}
+
+	// Each path was counted from both of its ends. Normalize by the number of node pairs that a node could sit between.
+	n := float64(len(addrs))
+	if n > 2 {
+		scale := 1 / ((n - 1) * (n - 2))
+		for a := range centrality {
+			centrality[a] *= scale
+		}
+	} else {
+		for a := range centrality {
+			centrality[a] = 0
+		}
+	}
+
+	return diameter, centrality
+}
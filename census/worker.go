@@ -34,12 +34,52 @@ type Worker struct {
 
 	// This is used to determine if the worker is running
 	status int32
+
+	// This is set when the enumerator asks the worker to stop, so that its exit isn't treated as a failure
+	stopping int32
+
+	// How many times in a row this worker number has failed before this instance was started
+	failures int
+
+	// When the worker's soterd process was started
+	started time.Time
 }
 
 // checkNode attempts to check a node and update our information for it
 func (w *Worker) checkNode(n *Node) error {
 	defer n.free()
 
+	started := time.Now()
+	err := w.pollNode(n)
+	w.e.polls.record(time.Since(started), err)
+
+	return err
+}
+
+// pollNode polls a node for its version and peers, and measures its latency
+func (w *Worker) pollNode(n *Node) error {
+
+	// Remember the node's state before the check, so that we can publish events for what changed
+	n.updateLock.Lock()
+	attempted, wasOnline, prevVersion := n.attempted, n.Online, n.Version
+	n.attempted = true
+	n.updateLock.Unlock()
+	defer func() {
+		n.updateLock.RLock()
+		online, version := n.Online, n.Version
+		n.updateLock.RUnlock()
+
+		if wasOnline && !online {
+			w.e.publish(Event{Type: EventOffline, Address: n.Address, Version: version})
+		} else if attempted && !wasOnline && online {
+			w.e.publish(Event{Type: EventOnline, Address: n.Address, Version: version})
+		}
+
+		if len(prevVersion) > 0 && len(version) > 0 && prevVersion != version {
+			w.e.publish(Event{Type: EventVersionChanged, Address: n.Address, Version: version, PreviousVersion: prevVersion})
+		}
+	}()
+
 	c := w.soterd.Client()
 
 	info, err := c.GetInfo()
@@ -72,19 +112,44 @@ func (w *Worker) checkNode(n *Node) error {
 		return err
 	}
 
+	n.updateLock.RLock()
+	hops := n.Hops + 1
+	n.updateLock.RUnlock()
+
 	conns := make([]*Node, 0)
 	for _, p := range peers {
-		pn := Node{Address: p}
+		addr, err := w.e.normalize(p)
+		if err != nil {
+			log.Printf("worker %s	ignoring address %s from %s: %s", w, p, n, err)
+			continue
+		}
+
+		pn := Node{Address: addr, Hops: hops}
 		conns = append(conns, &pn)
 
 		// Add the node's peers to the survey for future polls, if they haven't been added already
 		w.e.AddToCensus(&pn)
 	}
 
+	// Measure the network quality between us and the node. Onion addresses can't be dialed directly.
+	var latency Latency
+	probed := false
+	if !isOnion(n.Address) {
+		latency, err = probe(n.Address, w.e.soterdNet)
+		if err != nil {
+			log.Printf("worker %s	error measuring latency of %s: %s", w, n, err)
+		} else {
+			probed = true
+		}
+	}
+
 	n.updateLock.Lock()
 	n.connections = conns
 	n.Online = true
 	n.LastChecked = time.Now()
+	if probed {
+		n.Latency = latency
+	}
 	n.updateLock.Unlock()
 
 	// Add the node to the survey for future polls, if it hasn't already
@@ -93,10 +158,11 @@ func (w *Worker) checkNode(n *Node) error {
 	return nil
 }
 
-// checkSeeds attempts to check all the seeds
+// checkSeeds attempts to check all the seeds.
+// Seeds that can't be reached are left for later polls, so that a dead seed doesn't hold up the census.
 func (w *Worker) checkSeeds() {
-	for !allFresh(w.e.seeds, w.e.Interval) {
-		for _, n := range w.e.seeds {
+	for seeds := w.e.seedNodes(); !allAttempted(seeds); seeds = w.e.seedNodes() {
+		for _, n := range seeds {
 			if n.reserve() {
 				log.Printf("worker %s\tchecking seed %s", w, n)
 				err := w.checkNode(n)
@@ -115,16 +181,18 @@ func (w *Worker) run() {
 	ticker := time.NewTicker(w.wait)
 
 	// Start soterd process
+	w.started = time.Now()
 	err := w.soterd.Start()
 
+	defer w.e.wg.Done()
+	defer w.exited()
 	defer func() {_ = w.soterd.Stop()}()
 	defer atomic.StoreInt32(&w.status, free)
 	defer ticker.Stop()
-	defer w.e.wg.Done()
 
 	if err != nil {
 		errMsg := fmt.Errorf("worker %s failed to start soterd process: %s", w, err)
-		w.e.workerNotifications <- errMsg.Error()
+		w.notify(errMsg.Error())
 		return
 	}
 
@@ -149,6 +217,40 @@ func (w *Worker) run() {
 	}
 }
 
+// notify sends a message to the enumerator, unless the worker or enumerator is shutting down
+func (w *Worker) notify(m string) {
+	select {
+		case w.e.workerNotifications <- m:
+		case <-w.quit:
+		case <-w.e.quit:
+	}
+}
+
+// exited lets the enumerator know that the worker is no longer running, so that it can be restarted if needed
+func (w *Worker) exited() {
+	select {
+		case w.e.workerExits <- w:
+		case <-w.e.quit:
+	}
+}
+
+// stop asks the worker to shut down
+func (w *Worker) stop() {
+	if atomic.CompareAndSwapInt32(&w.stopping, 0, 1) {
+		close(w.quit)
+	}
+}
+
+// isStopping returns true if the worker was asked to shut down
+func (w *Worker) isStopping() bool {
+	return atomic.LoadInt32(&w.stopping) == 1
+}
+
+// ranFor returns how long the worker ran for since its soterd process was started
+func (w *Worker) ranFor() time.Duration {
+	return time.Since(w.started)
+}
+
 // isRunning returns true if the worker is currently running
 func (w *Worker) isRunning() bool {
 	v := atomic.LoadInt32(&w.status)
@@ -0,0 +1,272 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/rpcclient"
+	"github.com/soteria-dag/soterd/soterjson"
+)
+
+const (
+	// How many coloring flips are kept
+	coloringFlipLimit = 1000
+)
+
+// coloringFlip is a block changing between blue and red in an RPC node's dag coloring
+type coloringFlip struct {
+	RPCNode int `json:"rpcNode"`
+	Hash string `json:"hash"`
+	// The block's height, or -1 if it couldn't be looked up
+	Height int32 `json:"height"`
+	// The block's coloring after the flip. It was the opposite before.
+	IsBlue bool `json:"isBlue"`
+	// When the flip was seen, and the node's dag tips at that moment
+	Time time.Time `json:"time"`
+	Tips []string `json:"tips"`
+}
+
+// From returns the block's color before the flip
+func (f coloringFlip) From() string {
+	if f.IsBlue {
+		return "red"
+	}
+	return "blue"
+}
+
+// To returns the block's color after the flip
+func (f coloringFlip) To() string {
+	if f.IsBlue {
+		return "blue"
+	}
+	return "red"
+}
+
+// coloringTracker keeps track of each RPC node's dag coloring, and records the blocks that flip between blue and red
+type coloringTracker struct {
+	// Maps RPC node id to the last seen coloring of each block, by hash
+	colors map[int]map[string]bool
+	// Maps RPC node id to when the last applied coloring was fetched, so that older results are ignored
+	fetched map[int]time.Time
+	// Maps RPC node id to how many times each block has flipped, by hash
+	flipCounts map[int]map[string]int
+
+	// Recorded flips, newest first
+	flips []coloringFlip
+
+	lock sync.RWMutex
+}
+
+// newColoringTracker returns an empty coloringTracker
+func newColoringTracker() *coloringTracker {
+	return &coloringTracker{
+		colors: make(map[int]map[string]bool),
+		fetched: make(map[int]time.Time),
+		flipCounts: make(map[int]map[string]int),
+	}
+}
+
+// clientID returns the id of the RPC client, or -1 if it isn't one of the connected clients
+func clientID(c *rpcclient.Client) int {
+	for id, client := range clients {
+		if client == c {
+			return id
+		}
+	}
+	return -1
+}
+
+// observe records the coloring of the RPC node's blocks, which was fetched at the time. Blocks whose coloring changed
+// since the last observation are recorded as flips, along with the node's tips.
+func (t *coloringTracker) observe(c *rpcclient.Client, coloring []*soterjson.GetDAGColoringResult, tips []string, fetched time.Time) {
+	id := clientID(c)
+	if id < 0 {
+		return
+	}
+
+	t.lock.Lock()
+	if fetched.Before(t.fetched[id]) {
+		// A newer coloring has already been applied, so this one would look like flips back to an older state
+		t.lock.Unlock()
+		return
+	}
+	t.fetched[id] = fetched
+
+	colors, seen := t.colors[id]
+	if !seen {
+		colors = make(map[string]bool)
+		t.colors[id] = colors
+		t.flipCounts[id] = make(map[string]int)
+	}
+
+	var flips []coloringFlip
+	for _, b := range coloring {
+		wasBlue, exists := colors[b.Hash]
+		colors[b.Hash] = b.IsBlue
+		if !exists || wasBlue == b.IsBlue {
+			continue
+		}
+
+		t.flipCounts[id][b.Hash]++
+		flips = append(flips, coloringFlip{
+			RPCNode: id,
+			Hash: b.Hash,
+			Height: -1,
+			IsBlue: b.IsBlue,
+			Time: fetched,
+			Tips: tips,
+		})
+	}
+	t.lock.Unlock()
+
+	if len(flips) == 0 {
+		return
+	}
+
+	// Look up the heights of the flipped blocks without holding the lock. Flips are rare, so this is cheap.
+	for i := range flips {
+		hash, err := chainhash.NewHashFromStr(flips[i].Hash)
+		if err != nil {
+			continue
+		}
+		header, err := c.GetBlockHeaderVerbose(hash)
+		if err != nil {
+			log.Printf("Failed to look up height of flipped block %s on rpc node %d: %s", flips[i].Hash, id, err)
+			continue
+		}
+		flips[i].Height = header.Height
+	}
+
+	for _, f := range flips {
+		log.Printf("Block %s flipped from %s to %s on rpc node %d", f.Hash, f.From(), f.To(), f.RPCNode)
+		coloringFlips.WithLabelValues(strconv.Itoa(f.RPCNode)).Inc()
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, f := range flips {
+		t.flips = append([]coloringFlip{f}, t.flips...)
+	}
+	if len(t.flips) > coloringFlipLimit {
+		t.flips = t.flips[:coloringFlipLimit]
+	}
+}
+
+// history returns the recorded flips, newest first. If node isn't negative, only that RPC node's flips are returned.
+func (t *coloringTracker) history(node int) []coloringFlip {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	flips := make([]coloringFlip, 0)
+	for _, f := range t.flips {
+		if node < 0 || f.RPCNode == node {
+			flips = append(flips, f)
+		}
+	}
+
+	return flips
+}
+
+// flipCount returns how many times the block has flipped on the RPC node
+func (t *coloringTracker) flipCount(c *rpcclient.Client, hash string) int {
+	id := clientID(c)
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.flipCounts[id][hash]
+}
+
+// fetchColoring returns the RPC node's dag coloring and tips, and records the coloring with the tracker
+func fetchColoring(c *rpcclient.Client) ([]*soterjson.GetDAGColoringResult, *soterjson.GetDAGTipsResult, error) {
+	fetched := time.Now().Round(0)
+
+	tips, err := c.GetDAGTips()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := c.GetDAGColoring()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	colorTracker.observe(c, result, tips.Tips, fetched)
+
+	return result, tips, nil
+}
+
+// coloringPoller fetches the RPC nodes' dag coloring on an interval, so that flips are seen without anyone viewing the dag
+type coloringPoller struct {
+	interval time.Duration
+
+	started int32
+	shutdown int32
+	wg sync.WaitGroup
+	quit chan struct{}
+}
+
+// newColoringPoller returns a coloringPoller that polls on the interval
+func newColoringPoller(interval time.Duration) *coloringPoller {
+	return &coloringPoller{
+		interval: interval,
+		quit: make(chan struct{}),
+	}
+}
+
+// poll fetches each RPC node's dag coloring
+func (p *coloringPoller) poll() {
+	for id, c := range clients {
+		_, _, err := fetchColoring(c)
+		if err != nil {
+			log.Printf("Failed to get dag coloring of rpc node %d: %s", id, err)
+		}
+	}
+}
+
+// run polls the RPC nodes until the poller is stopped
+func (p *coloringPoller) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.poll()
+	for {
+		select {
+			case <-ticker.C:
+				p.poll()
+			case <-p.quit:
+				return
+		}
+	}
+}
+
+// Start polling in a new goroutine
+func (p *coloringPoller) Start() {
+	if atomic.AddInt32(&p.started, 1) != 1 {
+		return
+	}
+
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop polling
+func (p *coloringPoller) Stop() {
+	if atomic.AddInt32(&p.shutdown, 1) != 1 {
+		return
+	}
+
+	close(p.quit)
+	p.wg.Wait()
+}
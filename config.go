@@ -0,0 +1,545 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/soteria-dag/soterdash/phantom"
+	"github.com/soteria-dag/soterd/chaincfg"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// Environment variables are named with this prefix
+	envPrefix = "SOTERDASH_"
+
+	// What secrets are replaced with when the config is dumped
+	redacted = "<redacted>"
+)
+
+// The p2p networks that the census can run on, by name
+var networks = map[string]*chaincfg.Params{
+	"mainnet": &chaincfg.MainNetParams,
+	"testnet": &chaincfg.TestNet1Params,
+	"regnet": &chaincfg.RegressionNetParams,
+	"simnet": &chaincfg.SimNetParams,
+}
+
+// envFlags maps environment variables (without the prefix) to the flag they override
+var envFlags = map[string]string{
+	"CONFIG": "config",
+	"LISTEN": "l",
+	"NETWORK": "net",
+	"RPC_NODES": "r",
+	"RPC_USER": "u",
+	"RPC_PASS": "p",
+	"RPC_PASSFILE": "pfile",
+	"RPC_CERT": "c",
+	"RPC_BWINTERVAL": "bwinterval",
+	"RPC_BWKEEP": "bwkeep",
+	"RPC_COLORINGINTERVAL": "coloringinterval",
+	"RPC_COLORINGK": "coloringk",
+	"CENSUS_INTERVAL": "i",
+	"CENSUS_WORKERS": "w",
+	"CENSUS_MINWORKERS": "wmin",
+	"CENSUS_MAXWORKERS": "wmax",
+	"CENSUS_AUTOSCALE": "autoscale",
+	"CENSUS_ALLOW": "allow",
+	"CENSUS_DENY": "deny",
+	"CENSUS_SEEDFILE": "seedfile",
+	"CENSUS_DNSSEED": "dnsseed",
+	"CENSUS_DNSRESOLVER": "dnsresolver",
+	"CENSUS_GEODB": "geodb",
+	"CENSUS_ASNDB": "asndb",
+	"CENSUS_SNAPSHOTS": "snapshots",
+	"CENSUS_SNAPSHOTKEEP": "snapshotkeep",
+	"FEDERATION_TOKEN": "fedtoken",
+	"FEDERATION_TOKENFILE": "fedtokenfile",
+	"FEDERATION_PUSH": "push",
+	"FEDERATION_AGENT": "agent",
+	"FEDERATION_PUSHINTERVAL": "pushinterval",
+	"UI_BRAND": "brand",
+	"METRICS_INTERVAL": "metricsinterval",
+	"ALERTS_INTERVAL": "alertinterval",
+	"ALERTS_WEBHOOKS": "webhooks",
+	"TLS_CERT": "tlscert",
+	"TLS_KEY": "tlskey",
+	"TLS_GENERATE": "tlsgen",
+}
+
+// config is the soterdash configuration. Settings come from the defaults, then the config file, then environment
+// variables, then command-line flags, with later ones taking precedence.
+type config struct {
+	// Which [ip]:port to listen on
+	Listen string `yaml:"listen"`
+	// The p2p network for census worker connections: mainnet, testnet, regnet or simnet
+	Network string `yaml:"network"`
+	RPC rpcConfig `yaml:"rpc"`
+	Census censusConfig `yaml:"census"`
+	Federation federationConfig `yaml:"federation"`
+	UI uiConfig `yaml:"ui"`
+	Metrics metricsConfig `yaml:"metrics"`
+	Alerts alertsConfig `yaml:"alerts"`
+	TLS tlsConfig `yaml:"tls"`
+	Auth authConfig `yaml:"auth"`
+}
+
+// Settings for connecting to soterd RPC nodes
+type rpcConfig struct {
+	Nodes []string `yaml:"nodes"`
+	User string `yaml:"user"`
+	Pass string `yaml:"pass"`
+	// A file to read the password from, instead of giving it in the config or on the command line
+	PassFile string `yaml:"passfile"`
+	Cert string `yaml:"cert"`
+	// How often RPC nodes' network traffic is sampled, and how long it's kept for
+	BandwidthInterval string `yaml:"bwinterval"`
+	BandwidthKeep string `yaml:"bwkeep"`
+	// How often RPC nodes' dag coloring is checked for blocks flipping between blue and red
+	ColoringInterval string `yaml:"coloringinterval"`
+	// The PHANTOM k value that the network colors its dag with, used to verify the RPC nodes' coloring
+	ColoringK int `yaml:"coloringk"`
+}
+
+// Settings for the p2p network census
+type censusConfig struct {
+	Interval string `yaml:"interval"`
+	Workers int `yaml:"workers"`
+	MinWorkers int `yaml:"minworkers"`
+	MaxWorkers int `yaml:"maxworkers"`
+	Autoscale bool `yaml:"autoscale"`
+	// CIDR blocks or address classes to always include in, or exclude from the census
+	Allow []string `yaml:"allow"`
+	Deny []string `yaml:"deny"`
+	SeedFile string `yaml:"seedfile"`
+	DNSSeed bool `yaml:"dnsseed"`
+	DNSResolver string `yaml:"dnsresolver"`
+	GeoDB string `yaml:"geodb"`
+	ASNDB string `yaml:"asndb"`
+	Snapshots string `yaml:"snapshots"`
+	SnapshotKeep string `yaml:"snapshotkeep"`
+}
+
+// Settings for census agents and the central soterdash they push to
+type federationConfig struct {
+	Token string `yaml:"token"`
+	// A file to read the token from, instead of giving it in the config or on the command line
+	TokenFile string `yaml:"tokenfile"`
+	Push string `yaml:"push"`
+	Agent string `yaml:"agent"`
+	PushInterval string `yaml:"pushinterval"`
+}
+
+// Settings for the web ui
+type uiConfig struct {
+	// The name shown in the navbar
+	Brand string `yaml:"brand"`
+}
+
+// Settings for the Prometheus metrics served at /metrics
+type metricsConfig struct {
+	// How often RPC nodes are polled for metrics
+	Interval string `yaml:"interval"`
+}
+
+// Settings for alert rules, and the webhooks notified when alerts fire and resolve
+type alertsConfig struct {
+	// How often the rules are evaluated
+	Interval string `yaml:"interval"`
+	// URLs that alerts are posted to as JSON
+	Webhooks []string `yaml:"webhooks"`
+	// How many resolved alerts are kept for the /alerts page
+	History int `yaml:"history"`
+	Rules []alertRuleConfig `yaml:"rules"`
+}
+
+// An alert rule
+type alertRuleConfig struct {
+	Name string `yaml:"name"`
+	// tipstall, lowpeers, heightspread, censusdrop or rpcdown
+	Kind string `yaml:"kind"`
+	// The RPC node id the rule applies to (default is every RPC node)
+	Node *int `yaml:"node"`
+	// The peer count, height difference or percentage that the rule compares with
+	Threshold float64 `yaml:"threshold"`
+	// The stall duration of tipstall rules, and the comparison window of censusdrop rules
+	Duration string `yaml:"duration"`
+	// How long the condition must hold before the alert fires
+	For string `yaml:"for"`
+}
+
+// Settings for serving the dashboard over HTTPS
+type tlsConfig struct {
+	// Certificate and key files. If neither is set, the dashboard is served over plain HTTP.
+	Cert string `yaml:"cert"`
+	Key string `yaml:"key"`
+	// Generate a self-signed certificate and key at the paths, if they don't exist
+	Generate bool `yaml:"generate"`
+}
+
+// Settings for authenticating dashboard users. If no users or tokens are set, no authentication is required.
+type authConfig struct {
+	// Users that log in with HTTP basic authentication
+	Users []authUserConfig `yaml:"users"`
+	// Static bearer tokens, for API clients
+	Tokens []authTokenConfig `yaml:"tokens"`
+}
+
+// A user that logs in with HTTP basic authentication
+type authUserConfig struct {
+	Name string `yaml:"name"`
+	// The bcrypt hash of the user's password (see -hashpass)
+	Hash string `yaml:"hash"`
+	// viewer (the default) or admin
+	Role string `yaml:"role"`
+}
+
+// A static bearer token
+type authTokenConfig struct {
+	// A name for the token, used in logs
+	Name string `yaml:"name"`
+	Token string `yaml:"token"`
+	// A file to read the token from, instead of giving it in the config
+	TokenFile string `yaml:"tokenfile"`
+	// viewer (the default) or admin
+	Role string `yaml:"role"`
+}
+
+// defaultConfig returns the configuration used when nothing else is set
+func defaultConfig(certPath string) *config {
+	return &config{
+		Listen: ":5072",
+		RPC: rpcConfig{
+			Cert: certPath,
+			BandwidthInterval: "10s",
+			BandwidthKeep: "1h",
+			ColoringInterval: "30s",
+			ColoringK: phantom.DefaultK,
+		},
+		Census: censusConfig{
+			Interval: "15s",
+			Workers: 2,
+			MinWorkers: 1,
+			MaxWorkers: 8,
+			DNSSeed: true,
+			Snapshots: "1m",
+			SnapshotKeep: "24h",
+		},
+		Federation: federationConfig{
+			Agent: defaultAgentName(),
+			PushInterval: "1m",
+		},
+		UI: uiConfig{
+			Brand: "soterdash",
+		},
+		Metrics: metricsConfig{
+			Interval: "15s",
+		},
+		Alerts: alertsConfig{
+			Interval: "30s",
+			History: 100,
+		},
+	}
+}
+
+// listValue is a flag.Value for a comma-separated list
+type listValue struct {
+	list *[]string
+}
+
+func (v listValue) String() string {
+	if v.list == nil {
+		return ""
+	}
+	return strings.Join(*v.list, ",")
+}
+
+func (v listValue) Set(s string) error {
+	*v.list = nil
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			*v.list = append(*v.list, item)
+		}
+	}
+	return nil
+}
+
+// networkValue is a boolean flag.Value that selects a p2p network
+type networkValue struct {
+	network *string
+	name string
+}
+
+func (v networkValue) IsBoolFlag() bool {
+	return true
+}
+
+func (v networkValue) String() string {
+	if v.network == nil {
+		return "false"
+	}
+	return fmt.Sprint(*v.network == v.name)
+}
+
+func (v networkValue) Set(s string) error {
+	switch s {
+	case "true":
+		*v.network = v.name
+	case "false":
+		if *v.network == v.name {
+			*v.network = ""
+		}
+	default:
+		return fmt.Errorf("invalid boolean value '%s'", s)
+	}
+	return nil
+}
+
+// defineFlags defines the command-line flags that set the config's settings
+func defineFlags(fs *flag.FlagSet, cfg *config) {
+	fs.String("config", "", "Path to a YAML config file")
+	fs.Bool("dump-config", false, "Print the effective config, with secrets redacted, and exit")
+
+	fs.StringVar(&cfg.Listen, "l", cfg.Listen, "Which [ip]:port to listen on")
+	fs.StringVar(&cfg.Network, "net", cfg.Network, "P2p network for soterd census worker connections (mainnet, testnet, regnet, simnet)")
+	for _, name := range []string{"mainnet", "testnet", "regnet", "simnet"} {
+		fs.Var(networkValue{network: &cfg.Network, name: name}, name, fmt.Sprintf("Use %s for soterd network census worker connections (same as -net %s)", name, name))
+	}
+
+	fs.Var(listValue{list: &cfg.RPC.Nodes}, "r", "Comma-separated `list` of soterd RPC ip:port addresses to connect to")
+	fs.StringVar(&cfg.RPC.User, "u", cfg.RPC.User, "Soterd RPC username")
+	fs.StringVar(&cfg.RPC.Pass, "p", cfg.RPC.Pass, "Soterd RPC password (prefer -pfile, so that it isn't visible in the process list)")
+	fs.StringVar(&cfg.RPC.PassFile, "pfile", cfg.RPC.PassFile, "Path to a file containing the soterd RPC password")
+	fs.StringVar(&cfg.RPC.Cert, "c", cfg.RPC.Cert, "Soterd RPC certificate path")
+	fs.StringVar(&cfg.RPC.BandwidthInterval, "bwinterval", cfg.RPC.BandwidthInterval, "Time interval for sampling RPC nodes' network traffic")
+	fs.StringVar(&cfg.RPC.BandwidthKeep, "bwkeep", cfg.RPC.BandwidthKeep, "How long to keep RPC nodes' network traffic history for")
+	fs.StringVar(&cfg.RPC.ColoringInterval, "coloringinterval", cfg.RPC.ColoringInterval, "Time interval for checking RPC nodes' dag coloring for blocks flipping between blue and red")
+	fs.IntVar(&cfg.RPC.ColoringK, "coloringk", cfg.RPC.ColoringK, "PHANTOM k value of the network, for verifying RPC nodes' dag coloring")
+
+	fs.StringVar(&cfg.Census.Interval, "i", cfg.Census.Interval, "Time interval for polling nodes")
+	fs.IntVar(&cfg.Census.Workers, "w", cfg.Census.Workers, "Number of p2p network census workers to start")
+	fs.IntVar(&cfg.Census.MinWorkers, "wmin", cfg.Census.MinWorkers, "Minimum number of census workers, when autoscaling")
+	fs.IntVar(&cfg.Census.MaxWorkers, "wmax", cfg.Census.MaxWorkers, "Maximum number of census workers, when autoscaling")
+	fs.BoolVar(&cfg.Census.Autoscale, "autoscale", cfg.Census.Autoscale, "Scale the number of census workers based on how many nodes are overdue for polling")
+	fs.Var(listValue{list: &cfg.Census.Allow}, "allow", "Comma-separated `list` of CIDR blocks or address classes (all, private, loopback, onion, unroutable) to always include in the census")
+	fs.Var(listValue{list: &cfg.Census.Deny}, "deny", "Comma-separated `list` of CIDR blocks or address classes (all, private, loopback, onion, unroutable) to exclude from the census")
+	fs.StringVar(&cfg.Census.SeedFile, "seedfile", cfg.Census.SeedFile, "Path to a file of census seed addresses, one per line")
+	fs.BoolVar(&cfg.Census.DNSSeed, "dnsseed", cfg.Census.DNSSeed, "Use the network's DNS seeds as census seeds")
+	fs.StringVar(&cfg.Census.DNSResolver, "dnsresolver", cfg.Census.DNSResolver, "DNS server ip:port to resolve DNS seeds with (default is the system resolver)")
+	fs.StringVar(&cfg.Census.GeoDB, "geodb", cfg.Census.GeoDB, "Path to a MaxMind-format (mmdb) country or city database, for locating census nodes")
+	fs.StringVar(&cfg.Census.ASNDB, "asndb", cfg.Census.ASNDB, "Path to a MaxMind-format (mmdb) ASN database, for finding census nodes' networks")
+	fs.StringVar(&cfg.Census.Snapshots, "snapshots", cfg.Census.Snapshots, "Time interval for taking census snapshots, used for comparing the census over time")
+	fs.StringVar(&cfg.Census.SnapshotKeep, "snapshotkeep", cfg.Census.SnapshotKeep, "How long to keep census snapshots for")
+
+	fs.StringVar(&cfg.Federation.Token, "fedtoken", cfg.Federation.Token, "Shared token that census agents authenticate with, when pushing reports to a central soterdash")
+	fs.StringVar(&cfg.Federation.TokenFile, "fedtokenfile", cfg.Federation.TokenFile, "Path to a file containing the federation token")
+	fs.StringVar(&cfg.Federation.Push, "push", cfg.Federation.Push, "Run as a census agent, pushing census reports to this central soterdash URL (like http://host:5072)")
+	fs.StringVar(&cfg.Federation.Agent, "agent", cfg.Federation.Agent, "Name to report as, when running as a census agent")
+	fs.StringVar(&cfg.Federation.PushInterval, "pushinterval", cfg.Federation.PushInterval, "Time interval for pushing census reports, when running as a census agent")
+
+	fs.StringVar(&cfg.UI.Brand, "brand", cfg.UI.Brand, "Name shown in the web ui's navbar")
+
+	fs.StringVar(&cfg.Metrics.Interval, "metricsinterval", cfg.Metrics.Interval, "Time interval for polling RPC nodes for the Prometheus metrics at /metrics")
+
+	fs.StringVar(&cfg.Alerts.Interval, "alertinterval", cfg.Alerts.Interval, "Time interval for evaluating alert rules")
+	fs.Var(listValue{list: &cfg.Alerts.Webhooks}, "webhooks", "Comma-separated `list` of URLs to post alerts to as JSON, when they fire and resolve")
+
+	fs.StringVar(&cfg.TLS.Cert, "tlscert", cfg.TLS.Cert, "Path to a TLS certificate, for serving the web ui over HTTPS")
+	fs.StringVar(&cfg.TLS.Key, "tlskey", cfg.TLS.Key, "Path to the TLS certificate's key")
+	fs.BoolVar(&cfg.TLS.Generate, "tlsgen", cfg.TLS.Generate, "Generate a self-signed TLS certificate and key at -tlscert and -tlskey, if they don't exist")
+	fs.Bool("hashpass", false, "Read a password from stdin, print its bcrypt hash for the auth.users config, and exit")
+}
+
+// envValues returns the flag values set by environment variables
+func envValues(fs *flag.FlagSet) (map[string]string, error) {
+	values := make(map[string]string)
+	for env, name := range envFlags {
+		v, exists := os.LookupEnv(envPrefix + env)
+		if !exists {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("environment variable %s%s sets unknown flag -%s", envPrefix, env, name)
+		}
+		values[name] = v
+	}
+
+	return values, nil
+}
+
+// loadConfig applies the config file and environment variables to the config, under the command-line flags that
+// have already been parsed into it. The config must be the one that the flags were defined with.
+func loadConfig(fs *flag.FlagSet, cfg *config, defaults *config) error {
+	// Remember the flags given on the command line, so they can be re-applied over the config file and environment
+	cli := make(map[string]string)
+	networkFlags := 0
+	fs.Visit(func(f *flag.Flag) {
+		cli[f.Name] = f.Value.String()
+		if _, isNetwork := networks[f.Name]; isNetwork || f.Name == "net" {
+			networkFlags++
+		}
+	})
+	if networkFlags > 1 {
+		return fmt.Errorf("must choose only one p2p network for soterd census workers (-net, -mainnet, -testnet, -regnet, -simnet)")
+	}
+
+	env, err := envValues(fs)
+	if err != nil {
+		return err
+	}
+
+	path := cli["config"]
+	if len(path) == 0 {
+		path = env["config"]
+	}
+
+	*cfg = *defaults
+	if len(path) > 0 {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %s", err)
+		}
+
+		err = yaml.UnmarshalStrict(data, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %s: %s", path, err)
+		}
+	}
+
+	// Apply environment variables in a consistent order, then the command-line flags
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := fs.Set(name, env[name])
+		if err != nil {
+			return fmt.Errorf("invalid value '%s' for -%s from the environment: %s", env[name], name, err)
+		}
+	}
+	for name, v := range cli {
+		err := fs.Set(name, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cfg.readSecrets()
+	if err != nil {
+		return err
+	}
+
+	return cfg.validate()
+}
+
+// readSecretFile returns the contents of a file holding a secret, without surrounding whitespace
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readSecrets reads the RPC password and federation token from their files, if they're set
+func (c *config) readSecrets() error {
+	if len(c.RPC.PassFile) > 0 {
+		pass, err := readSecretFile(c.RPC.PassFile)
+		if err != nil {
+			return fmt.Errorf("failed to read rpc password file: %s", err)
+		}
+		c.RPC.Pass = pass
+	}
+
+	if len(c.Federation.TokenFile) > 0 {
+		token, err := readSecretFile(c.Federation.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read federation token file: %s", err)
+		}
+		c.Federation.Token = token
+	}
+
+	// Copy the tokens, so that the defaults they may have come from aren't changed
+	tokens := make([]authTokenConfig, len(c.Auth.Tokens))
+	copy(tokens, c.Auth.Tokens)
+	for i, t := range tokens {
+		if len(t.TokenFile) == 0 {
+			continue
+		}
+		token, err := readSecretFile(t.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read auth token file of %s: %s", t.Name, err)
+		}
+		tokens[i].Token = token
+	}
+	c.Auth.Tokens = tokens
+
+	return nil
+}
+
+// validate checks settings that depend on each other
+func (c *config) validate() error {
+	if c.RPC.ColoringK < 0 {
+		return fmt.Errorf("coloring k value can't be negative (-coloringk)")
+	}
+	if (len(c.TLS.Cert) > 0) != (len(c.TLS.Key) > 0) {
+		return fmt.Errorf("must set both the TLS certificate and key (-tlscert, -tlskey)")
+	}
+	if c.TLS.Generate && len(c.TLS.Cert) == 0 {
+		return fmt.Errorf("must set the TLS certificate and key paths to generate them at (-tlscert, -tlskey)")
+	}
+
+	return nil
+}
+
+// netParams returns the parameters of the config's p2p network
+func (c *config) netParams() (*chaincfg.Params, error) {
+	if len(c.Network) == 0 {
+		return nil, fmt.Errorf("must choose p2p network for soterd census workers (-net, -mainnet, -testnet, -regnet, -simnet)")
+	}
+
+	params, exists := networks[c.Network]
+	if !exists {
+		return nil, fmt.Errorf("unknown p2p network '%s' (use mainnet, testnet, regnet or simnet)", c.Network)
+	}
+
+	return params, nil
+}
+
+// dump writes the config as YAML, with secrets redacted
+func (c *config) dump(w io.Writer) error {
+	cp := *c
+	if len(cp.RPC.Pass) > 0 {
+		cp.RPC.Pass = redacted
+	}
+	if len(cp.Federation.Token) > 0 {
+		cp.Federation.Token = redacted
+	}
+	cp.Auth.Tokens = make([]authTokenConfig, len(c.Auth.Tokens))
+	for i, t := range c.Auth.Tokens {
+		if len(t.Token) > 0 {
+			t.Token = redacted
+		}
+		cp.Auth.Tokens[i] = t
+	}
+
+	data, err := yaml.Marshal(&cp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,390 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/rpcclient"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// How many generations below the tips are sampled by default
+	difficultyDefaultRange = int32(100)
+
+	// The most generations that are sampled for a difficulty chart
+	difficultyFetchLimit = int32(1000)
+)
+
+// blockDifficulty is the difficulty of a block
+type blockDifficulty struct {
+	Hash string
+	Height int32
+	Bits uint32
+	Difficulty float64
+	Timestamp time.Time
+	// The time since the median timestamp of the generation below, or zero if it wasn't sampled
+	Interval time.Duration
+}
+
+// generationDifficulty is the difficulty of the blocks at a height
+type generationDifficulty struct {
+	Height int32
+	Blocks []blockDifficulty
+	// The median of the blocks' difficulties and timestamps
+	Bits uint32
+	Difficulty float64
+	Timestamp time.Time
+	// The time between the median timestamps of this generation and the one below, or zero if it wasn't sampled
+	Interval time.Duration
+	// Whether the height is a retarget point
+	Retarget bool
+	// Whether the median difficulty differs from the generation below
+	Changed bool
+}
+
+// retargetSettings are a network's difficulty retarget settings
+type retargetSettings struct {
+	TargetTimespan time.Duration
+	TargetTimePerBlock time.Duration
+	AdjustmentFactor int64
+	BlocksPerRetarget int32
+	ReduceMinDifficulty bool
+	MinDiffReductionTime time.Duration
+	PowLimitBits uint32
+}
+
+// nextTarget is the target expected of the block after the latest one
+type nextTarget struct {
+	Height int32
+	Bits uint32
+	Difficulty float64
+	// Why the target is expected
+	Reason string
+
+	// The next retarget point, and the target it's projected to have if blocks keep coming at the current pace
+	RetargetHeight int32
+	ProjectedBits uint32
+	ProjectedDifficulty float64
+	// The time the blocks since the previous retarget point took, and how many of them there were
+	Elapsed time.Duration
+	Blocks int32
+}
+
+// Target returns the expected target as a hex string
+func (n nextTarget) Target() string {
+	return fmt.Sprintf("%064x", blockdag.CompactToBig(n.Bits))
+}
+
+// ProjectedTarget returns the target projected for the next retarget point as a hex string
+func (n nextTarget) ProjectedTarget() string {
+	return fmt.Sprintf("%064x", blockdag.CompactToBig(n.ProjectedBits))
+}
+
+// newRetargetSettings returns the network's difficulty retarget settings
+func newRetargetSettings(params *chaincfg.Params) retargetSettings {
+	s := retargetSettings{
+		TargetTimespan: params.TargetTimespan,
+		TargetTimePerBlock: params.TargetTimePerBlock,
+		AdjustmentFactor: params.RetargetAdjustmentFactor,
+		ReduceMinDifficulty: params.ReduceMinDifficulty,
+		MinDiffReductionTime: params.MinDiffReductionTime,
+		PowLimitBits: params.PowLimitBits,
+	}
+	if params.TargetTimePerBlock > 0 {
+		s.BlocksPerRetarget = int32(params.TargetTimespan / params.TargetTimePerBlock)
+	}
+
+	return s
+}
+
+// isRetarget returns true if the difficulty is retargeted at the height
+func (s retargetSettings) isRetarget(height int32) bool {
+	return s.BlocksPerRetarget > 0 && height > 0 && height%s.BlocksPerRetarget == 0
+}
+
+// nextRetarget returns the first retarget point above the height
+func (s retargetSettings) nextRetarget(height int32) int32 {
+	if s.BlocksPerRetarget <= 0 {
+		return 0
+	}
+
+	return (height/s.BlocksPerRetarget + 1) * s.BlocksPerRetarget
+}
+
+// difficultyRatio returns how many times harder the target from the bits is than the network's easiest target. This
+// is how soterd reports a block's difficulty.
+func difficultyRatio(bits uint32, params *chaincfg.Params) float64 {
+	max := blockdag.CompactToBig(params.PowLimitBits)
+	target := blockdag.CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	ratio := new(big.Rat).SetFrac(max, target)
+	diff, err := strconv.ParseFloat(ratio.FloatString(8), 64)
+	if err != nil {
+		return 0
+	}
+
+	return diff
+}
+
+// retargetBits returns the bits of the target that follows the old one, when the blocks of a retarget window took
+// the actual timespan
+func retargetBits(oldBits uint32, actual time.Duration, params *chaincfg.Params) uint32 {
+	// Limit the amount of adjustment that can occur to the previous difficulty
+	minTimespan := params.TargetTimespan / time.Duration(params.RetargetAdjustmentFactor)
+	maxTimespan := params.TargetTimespan * time.Duration(params.RetargetAdjustmentFactor)
+	adjusted := actual
+	if adjusted < minTimespan {
+		adjusted = minTimespan
+	} else if adjusted > maxTimespan {
+		adjusted = maxTimespan
+	}
+
+	target := blockdag.CompactToBig(oldBits)
+	target.Mul(target, big.NewInt(int64(adjusted/time.Second)))
+	target.Div(target, big.NewInt(int64(params.TargetTimespan/time.Second)))
+	if target.Cmp(params.PowLimit) > 0 {
+		target.Set(params.PowLimit)
+	}
+
+	return blockdag.BigToCompact(target)
+}
+
+// medianBlock returns the block with the median value, by the less function
+func medianBlock(blocks []blockDifficulty, less func(a, b blockDifficulty) bool) blockDifficulty {
+	sorted := make([]blockDifficulty, len(blocks))
+	copy(sorted, blocks)
+	sort.Slice(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+
+	return sorted[len(sorted)/2]
+}
+
+// sampleGeneration returns the difficulty of the RPC node's blocks at the height
+func sampleGeneration(c *rpcclient.Client, params *chaincfg.Params, height int32) (generationDifficulty, error) {
+	g := generationDifficulty{
+		Height: height,
+		Blocks: make([]blockDifficulty, 0),
+		Retarget: newRetargetSettings(params).isRetarget(height),
+	}
+
+	hashes, err := c.GetBlockHash(int64(height))
+	if err != nil {
+		return g, err
+	}
+	if len(hashes) == 0 {
+		return g, fmt.Errorf("no blocks at height %d", height)
+	}
+
+	for _, hash := range hashes {
+		header, err := c.GetBlockHeader(hash)
+		if err != nil {
+			return g, err
+		}
+
+		g.Blocks = append(g.Blocks, blockDifficulty{
+			Hash: hash.String(),
+			Height: height,
+			Bits: header.Bits,
+			Difficulty: difficultyRatio(header.Bits, params),
+			Timestamp: header.Timestamp,
+		})
+	}
+
+	// Targets are compared, rather than difficulties, so that the median is of the exact bits
+	g.Bits = medianBlock(g.Blocks, func(a, b blockDifficulty) bool {
+		return blockdag.CompactToBig(a.Bits).Cmp(blockdag.CompactToBig(b.Bits)) < 0
+	}).Bits
+	g.Difficulty = difficultyRatio(g.Bits, params)
+	g.Timestamp = medianBlock(g.Blocks, func(a, b blockDifficulty) bool {
+		return a.Timestamp.Before(b.Timestamp)
+	}).Timestamp
+
+	return g, nil
+}
+
+// sampleDifficulty returns the difficulty of each generation of the RPC node's blocks between the heights. The
+// generation below the range is sampled too, so that the first generation's interval is known.
+func sampleDifficulty(c *rpcclient.Client, params *chaincfg.Params, minHeight, maxHeight int32) ([]generationDifficulty, error) {
+	generations := make([]generationDifficulty, 0)
+
+	var prev *generationDifficulty
+	if minHeight > 0 {
+		g, err := sampleGeneration(c, params, minHeight-1)
+		if err != nil {
+			return generations, err
+		}
+		prev = &g
+	}
+
+	for height := minHeight; height <= maxHeight; height++ {
+		g, err := sampleGeneration(c, params, height)
+		if err != nil {
+			return generations, err
+		}
+
+		if prev != nil {
+			g.Interval = g.Timestamp.Sub(prev.Timestamp)
+			g.Changed = g.Bits != prev.Bits
+			for i := range g.Blocks {
+				g.Blocks[i].Interval = g.Blocks[i].Timestamp.Sub(prev.Timestamp)
+			}
+		}
+
+		generations = append(generations, g)
+		prev = &generations[len(generations)-1]
+	}
+
+	return generations, nil
+}
+
+// latestBlock returns the RPC node's most recent block at the height, by timestamp
+func latestBlock(c *rpcclient.Client, height int32) (*wire.BlockHeader, error) {
+	hashes, err := c.GetBlockHash(int64(height))
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *wire.BlockHeader
+	for _, hash := range hashes {
+		header, err := c.GetBlockHeader(hash)
+		if err != nil {
+			return nil, err
+		}
+		if latest == nil || header.Timestamp.After(latest.Timestamp) {
+			latest = header
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no blocks at height %d", height)
+	}
+
+	return latest, nil
+}
+
+// firstBlock returns the first of the RPC node's blocks at the height, which is the one soterd retargets from
+func firstBlock(c *rpcclient.Client, height int32) (*wire.BlockHeader, error) {
+	hashes, err := c.GetBlockHash(int64(height))
+	if err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("no blocks at height %d", height)
+	}
+
+	return c.GetBlockHeader(hashes[0])
+}
+
+// prevDifficultyBits returns the bits of the first block, following first parents back from the block with the hash,
+// that isn't a minimum difficulty block or is at a retarget point, along with its height. This is the target soterd
+// keeps between retarget points on networks that allow minimum difficulty blocks.
+func prevDifficultyBits(c *rpcclient.Client, s retargetSettings, hash chainhash.Hash) (uint32, int32, error) {
+	for {
+		header, err := c.GetBlockHeaderVerbose(&hash)
+		if err != nil {
+			return 0, 0, err
+		}
+		block, err := c.GetBlock(&hash)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if block.Header.Bits != s.PowLimitBits || header.Height%s.BlocksPerRetarget == 0 {
+			return block.Header.Bits, header.Height, nil
+		}
+		if len(block.Parents.Parents) == 0 {
+			return s.PowLimitBits, header.Height, nil
+		}
+		hash = block.Parents.Parents[0].Hash
+	}
+}
+
+// calcNextTarget returns the target expected of the block after the RPC node's latest block at the height, by the
+// network's retarget settings. Between retarget points the target stays the same. Networks that allow minimum
+// difficulty blocks after a while without one keep the target of the latest block that wasn't one. At a retarget point
+// the target is scaled by how long the previous retarget window took, compared with the network's target timespan.
+//
+// NOTE: soterd measures block timestamps in seconds but retarget timespans in milliseconds, so its own retargets can
+// differ from the ones expected here.
+func calcNextTarget(c *rpcclient.Client, params *chaincfg.Params, maxHeight int32, now time.Time) (nextTarget, error) {
+	s := newRetargetSettings(params)
+	n := nextTarget{Height: maxHeight + 1}
+
+	last, err := latestBlock(c, maxHeight)
+	if err != nil {
+		return n, err
+	}
+
+	switch {
+	case s.BlocksPerRetarget <= 0:
+		n.Bits = last.Bits
+		n.Reason = fmt.Sprintf("%s doesn't retarget", params.Name)
+
+	case s.isRetarget(n.Height):
+		first, err := firstBlock(c, n.Height-s.BlocksPerRetarget)
+		if err != nil {
+			return n, err
+		}
+		actual := last.Timestamp.Sub(first.Timestamp)
+		n.Bits = retargetBits(last.Bits, actual, params)
+		n.Reason = fmt.Sprintf("retarget point: the blocks since height %d took %s, for a target timespan of %s",
+			n.Height-s.BlocksPerRetarget, actual, s.TargetTimespan)
+
+	case s.ReduceMinDifficulty && now.Sub(last.Timestamp) > s.MinDiffReductionTime:
+		n.Bits = s.PowLimitBits
+		n.Reason = fmt.Sprintf("no block for more than %s, so %s allows minimum difficulty", s.MinDiffReductionTime,
+			params.Name)
+
+	case s.ReduceMinDifficulty:
+		bits, height, err := prevDifficultyBits(c, s, last.BlockHash())
+		if err != nil {
+			return n, err
+		}
+		n.Bits = bits
+		n.Reason = fmt.Sprintf("same as the block at height %d, the latest one following first parents that's at a "+
+			"retarget point or doesn't have minimum difficulty, until the next retarget point", height)
+
+	default:
+		n.Bits = last.Bits
+		n.Reason = "same as the latest block, until the next retarget point"
+	}
+	n.Difficulty = difficultyRatio(n.Bits, params)
+
+	if s.BlocksPerRetarget <= 0 {
+		return n, nil
+	}
+
+	// Project the next retarget from the pace of the blocks since the previous one. Like the retarget itself, it's
+	// measured from the first block of the window to the last one before the retarget point.
+	n.RetargetHeight = s.nextRetarget(maxHeight)
+	windowStart := n.RetargetHeight - s.BlocksPerRetarget
+	n.Blocks = maxHeight - windowStart
+	if n.Blocks <= 0 {
+		return n, nil
+	}
+
+	first, err := firstBlock(c, windowStart)
+	if err != nil {
+		return n, err
+	}
+	n.Elapsed = last.Timestamp.Sub(first.Timestamp)
+	projected := n.Elapsed / time.Duration(n.Blocks) * time.Duration(s.BlocksPerRetarget-1)
+	n.ProjectedBits = retargetBits(last.Bits, projected, params)
+	n.ProjectedDifficulty = difficultyRatio(n.ProjectedBits, params)
+
+	return n, nil
+}
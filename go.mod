@@ -14,9 +14,13 @@ require (
 	github.com/golang/protobuf v1.3.1 // indirect
 	github.com/hpcloud/tail v1.0.0 // indirect
 	github.com/jessevdk/go-flags v1.4.0 // indirect
+	github.com/oschwald/geoip2-golang v1.9.0
+	github.com/prometheus/client_golang v0.9.2
 	github.com/soteria-dag/soterd v0.0.0-20191101002720-80c48f0843ed
 	github.com/wcharczuk/go-chart v2.0.1+incompatible
+	golang.org/x/crypto v0.0.0-20190621222207-cc06ce4a13d4
 	golang.org/x/image v0.0.0-20190321063152-3fc05d484e9f // indirect
 	gopkg.in/fsnotify.v1 v1.4.7 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.2.1
 )
@@ -0,0 +1,353 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/rpcclient"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// How many blocks' timestamps the median past time is taken over, following each block's first parent.
+	// This matches soterd's consensus rules.
+	medianTimeBlocks = 11
+
+	// How far ahead of our clock a block's timestamp can be
+	maxTimeOffset = blockdag.MaxTimeOffsetSeconds * time.Second
+)
+
+// integrityCheck is the result of one of the checks of a block's integrity
+type integrityCheck struct {
+	Name string
+	Passed bool
+	Details string
+}
+
+// blockIntegrity is the result of checking a block's integrity
+type blockIntegrity struct {
+	Hash string
+	Height int32
+	Checks []integrityCheck
+}
+
+// Passed returns true if every check passed
+func (b blockIntegrity) Passed() bool {
+	for _, c := range b.Checks {
+		if !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Failures returns the checks that failed
+func (b blockIntegrity) Failures() []integrityCheck {
+	failed := make([]integrityCheck, 0)
+	for _, c := range b.Checks {
+		if !c.Passed {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// ShortHash returns a shortened version of the block's hash
+func (b blockIntegrity) ShortHash() string {
+	return b.Hash[len(b.Hash)-smallHashLen:]
+}
+
+// integrityChecker checks the integrity of blocks from an RPC node. The blocks and heights it looks up along the way
+// are kept, so that checking a range of blocks doesn't fetch their shared ancestors again.
+type integrityChecker struct {
+	c *rpcclient.Client
+	params *chaincfg.Params
+
+	blocks map[string]*wire.MsgBlock
+	heights map[string]int32
+}
+
+// newIntegrityChecker returns an integrityChecker for blocks from the RPC node, on the network
+func newIntegrityChecker(c *rpcclient.Client, params *chaincfg.Params) *integrityChecker {
+	return &integrityChecker{
+		c: c,
+		params: params,
+		blocks: make(map[string]*wire.MsgBlock),
+		heights: make(map[string]int32),
+	}
+}
+
+// block returns the block with the hash
+func (ic *integrityChecker) block(hash chainhash.Hash) (*wire.MsgBlock, error) {
+	if b, ok := ic.blocks[hash.String()]; ok {
+		return b, nil
+	}
+
+	b, err := ic.c.GetBlock(&hash)
+	if err != nil {
+		return nil, err
+	}
+	ic.blocks[hash.String()] = b
+
+	return b, nil
+}
+
+// height returns the height of the block with the hash
+func (ic *integrityChecker) height(hash chainhash.Hash) (int32, error) {
+	if h, ok := ic.heights[hash.String()]; ok {
+		return h, nil
+	}
+
+	header, err := ic.c.GetBlockHeaderVerbose(&hash)
+	if err != nil {
+		return 0, err
+	}
+	ic.heights[hash.String()] = header.Height
+
+	return header.Height, nil
+}
+
+// check returns the result of checking the integrity of the block, which is at the height
+func (ic *integrityChecker) check(block *wire.MsgBlock, height int32) blockIntegrity {
+	hash := block.BlockHash()
+	ic.blocks[hash.String()] = block
+	ic.heights[hash.String()] = height
+
+	return blockIntegrity{
+		Hash: hash.String(),
+		Height: height,
+		Checks: []integrityCheck{
+			checkMerkleRoot(block),
+			checkWitnessCommitment(block),
+			checkProofOfWork(block, ic.params),
+			ic.checkParents(block, height),
+			ic.checkTimestamp(block),
+		},
+	}
+}
+
+// checkBlocks returns the results of checking the integrity of each block, which are given by height along with a map
+// of their hashes to their heights
+func (ic *integrityChecker) checkBlocks(dag [][]*wire.MsgBlock, heights map[string]int32) []blockIntegrity {
+	for hash, height := range heights {
+		ic.heights[hash] = height
+	}
+	for _, blocks := range dag {
+		for _, block := range blocks {
+			ic.blocks[block.BlockHash().String()] = block
+		}
+	}
+
+	results := make([]blockIntegrity, 0)
+	for _, blocks := range dag {
+		for _, block := range blocks {
+			results = append(results, ic.check(block, heights[block.BlockHash().String()]))
+		}
+	}
+
+	return results
+}
+
+// checkMerkleRoot compares the header's merkle root with the one computed from the block's transactions
+func checkMerkleRoot(block *wire.MsgBlock) integrityCheck {
+	c := integrityCheck{Name: "merkle root"}
+
+	txs := soterutil.NewBlock(block).Transactions()
+	if len(txs) == 0 {
+		c.Details = "block has no transactions"
+		return c
+	}
+
+	tree := blockdag.BuildMerkleTreeStore(txs, false)
+	root := tree[len(tree)-1]
+	if !block.Header.MerkleRoot.IsEqual(root) {
+		c.Details = fmt.Sprintf("header has %s, computed %s from %d transactions", block.Header.MerkleRoot, root, len(txs))
+		return c
+	}
+
+	c.Passed = true
+	c.Details = fmt.Sprintf("matches the root computed from %d transactions", len(txs))
+	return c
+}
+
+// checkWitnessCommitment checks the coinbase's witness commitment, if it has one, against the block's transactions
+func checkWitnessCommitment(block *wire.MsgBlock) integrityCheck {
+	c := integrityCheck{Name: "witness commitment"}
+
+	b := soterutil.NewBlock(block)
+	err := blockdag.ValidateWitnessCommitment(b)
+	if err != nil {
+		c.Details = err.Error()
+		return c
+	}
+
+	c.Passed = true
+	if _, found := blockdag.ExtractWitnessCommitment(b.Transactions()[0]); found {
+		c.Details = "matches the commitment computed from the transactions' witness data"
+	} else {
+		c.Details = "no commitment, and no transactions with witness data"
+	}
+	return c
+}
+
+// checkProofOfWork checks the block's proof of work against the target from its bits. Soterd blocks prove their work
+// with a cuckoo cycle, whose proof difficulty must meet the target. Blocks without a cycle are checked the way bitcoin
+// blocks are, with the header hash having to be at most the target.
+func checkProofOfWork(block *wire.MsgBlock, params *chaincfg.Params) integrityCheck {
+	c := integrityCheck{Name: "proof of work"}
+
+	target := blockdag.CompactToBig(block.Header.Bits)
+	if target.Sign() <= 0 {
+		c.Details = fmt.Sprintf("target %064x from bits %08x isn't positive", target, block.Header.Bits)
+		return c
+	}
+	if params != nil && target.Cmp(params.PowLimit) > 0 {
+		c.Details = fmt.Sprintf("target %064x from bits %08x is above the %s limit of %064x", target,
+			block.Header.Bits, params.Name, params.PowLimit)
+		return c
+	}
+
+	// NOTE: The cuckoo cycle itself is only verified by the external cuckoo verifier, which we don't run
+	if cycle := block.Verification.CycleNonces; len(cycle) > 0 {
+		proof := blockdag.ProofDifficulty(cycle)
+		if proof.Cmp(target) < 0 {
+			c.Details = fmt.Sprintf("cuckoo cycle proof difficulty %s is below the target %s from bits %08x", proof,
+				target, block.Header.Bits)
+			return c
+		}
+
+		c.Passed = true
+		c.Details = fmt.Sprintf("cuckoo cycle proof difficulty %s meets the target %s from bits %08x", proof, target,
+			block.Header.Bits)
+		return c
+	}
+
+	hash := block.BlockHash()
+	if blockdag.HashToBig(&hash).Cmp(target) > 0 {
+		c.Details = fmt.Sprintf("block has no cuckoo cycle, and its hash is above the target %064x from bits %08x",
+			target, block.Header.Bits)
+		return c
+	}
+
+	c.Passed = true
+	c.Details = fmt.Sprintf("block hash is at most the target %064x from bits %08x", target, block.Header.Bits)
+	return c
+}
+
+// checkParents checks that each of the block's parents exists, at a lower height than the block
+func (ic *integrityChecker) checkParents(block *wire.MsgBlock, height int32) integrityCheck {
+	c := integrityCheck{Name: "parents"}
+
+	parents := block.Parents.Parents
+	if len(parents) == 0 {
+		if height == 0 && ic.params != nil && block.BlockHash() == *ic.params.GenesisHash {
+			c.Passed = true
+			c.Details = "genesis block has no parents"
+			return c
+		}
+
+		c.Details = "block has no parents"
+		return c
+	}
+
+	problems := make([]string, 0)
+	for _, p := range parents {
+		pHeight, err := ic.height(p.Hash)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s not found: %s", p.Hash, err))
+			continue
+		}
+		if pHeight >= height {
+			problems = append(problems, fmt.Sprintf("%s is at height %d, not below %d", p.Hash, pHeight, height))
+		}
+	}
+
+	if len(problems) > 0 {
+		c.Details = strings.Join(problems, "; ")
+		return c
+	}
+
+	c.Passed = true
+	c.Details = fmt.Sprintf("all %d parents exist below height %d", len(parents), height)
+	return c
+}
+
+// medianPastTime returns the median timestamp of the block and its ancestors, following first parents, over
+// medianTimeBlocks blocks
+func (ic *integrityChecker) medianPastTime(block *wire.MsgBlock) (time.Time, error) {
+	timestamps := make([]int64, 0, medianTimeBlocks)
+	for b := block; b != nil && len(timestamps) < medianTimeBlocks; {
+		timestamps = append(timestamps, b.Header.Timestamp.Unix())
+
+		if len(b.Parents.Parents) == 0 {
+			break
+		}
+		parent, err := ic.block(b.Parents.Parents[0].Hash)
+		if err != nil {
+			return time.Time{}, err
+		}
+		b = parent
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	return time.Unix(timestamps[len(timestamps)/2], 0), nil
+}
+
+// checkTimestamp checks the block's timestamp against its parents, the way soterd does: it has to be after the median
+// past time of its most recent parent, and not too far in the future.
+func (ic *integrityChecker) checkTimestamp(block *wire.MsgBlock) integrityCheck {
+	c := integrityCheck{Name: "timestamp"}
+	ts := block.Header.Timestamp
+
+	if maxTime := time.Now().Add(maxTimeOffset); ts.After(maxTime) {
+		c.Details = fmt.Sprintf("%s is more than %s in the future", ts, maxTimeOffset)
+		return c
+	}
+
+	if len(block.Parents.Parents) == 0 {
+		c.Passed = true
+		c.Details = fmt.Sprintf("%s isn't in the future, and there are no parents to compare with", ts)
+		return c
+	}
+
+	var recent *wire.MsgBlock
+	for _, p := range block.Parents.Parents {
+		parent, err := ic.block(p.Hash)
+		if err != nil {
+			c.Details = fmt.Sprintf("couldn't fetch parent %s: %s", p.Hash, err)
+			return c
+		}
+		if recent == nil || parent.Header.Timestamp.After(recent.Header.Timestamp) {
+			recent = parent
+		}
+	}
+
+	median, err := ic.medianPastTime(recent)
+	if err != nil {
+		c.Details = fmt.Sprintf("couldn't fetch ancestors of parent %s: %s", recent.BlockHash(), err)
+		return c
+	}
+
+	if !ts.After(median) {
+		c.Details = fmt.Sprintf("%s isn't after %s, the median past time of its most recent parent %s", ts, median,
+			recent.BlockHash())
+		return c
+	}
+
+	c.Passed = true
+	c.Details = fmt.Sprintf("%s is after %s, the median past time of its most recent parent", ts, median)
+	return c
+}
@@ -0,0 +1,326 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/soteria-dag/soterd/rpcclient"
+	"github.com/soteria-dag/soterd/soterjson"
+	"github.com/soteria-dag/soterdash/census"
+)
+
+const (
+	// The prefix of every metric name
+	metricsNamespace = "soterdash"
+)
+
+var (
+	// The registry that /metrics is served from
+	metricsRegistry = prometheus.NewRegistry()
+
+	// RPC node state, updated by the metricsPoller
+	rpcUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rpc",
+		Name: "up",
+		Help: "Whether the last poll of the RPC node succeeded",
+	}, []string{"node"})
+	rpcTipMaxHeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rpc",
+		Name: "tip_max_height",
+		Help: "The highest height of the RPC node's dag tips",
+	}, []string{"node"})
+	rpcTipMinHeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rpc",
+		Name: "tip_min_height",
+		Help: "The lowest height of the RPC node's dag tips",
+	}, []string{"node"})
+	rpcBlocks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rpc",
+		Name: "blocks",
+		Help: "How many blocks the RPC node's dag has",
+	}, []string{"node"})
+	rpcTips = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rpc",
+		Name: "tips",
+		Help: "How many tips the RPC node's dag has",
+	}, []string{"node"})
+	rpcPeerCounts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rpc",
+		Name: "peers",
+		Help: "How many peers the RPC node is connected to",
+	}, []string{"node", "direction"})
+	rpcLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rpc",
+		Name: "request_duration_seconds",
+		Help: "How long the RPC node took to answer requests made for metrics",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"node", "method"})
+	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rpc",
+		Name: "request_errors_total",
+		Help: "How many requests made to the RPC node for metrics failed",
+	}, []string{"node", "method"})
+	coloringFlips = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rpc",
+		Name: "coloring_flips_total",
+		Help: "How many times blocks have flipped between blue and red in the RPC node's dag coloring",
+	}, []string{"node"})
+
+	// Web ui and API requests
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "http",
+		Name: "requests_total",
+		Help: "How many HTTP requests were served, by route",
+	}, []string{"route", "method", "code"})
+	httpLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "http",
+		Name: "request_duration_seconds",
+		Help: "How long HTTP requests took to serve, by route",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		rpcUp, rpcTipMaxHeight, rpcTipMinHeight, rpcBlocks, rpcTips, rpcPeerCounts, rpcLatency, rpcErrors, coloringFlips,
+		httpRequests, httpLatency,
+		censusCollector{},
+	)
+}
+
+// handleMetrics serves the metrics in Prometheus text format
+var handleMetrics = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+
+// metricsPoller polls the RPC nodes on an interval, so that scrapes read the last values instead of calling RPC nodes
+type metricsPoller struct {
+	interval time.Duration
+
+	started int32
+	shutdown int32
+	wg sync.WaitGroup
+	quit chan struct{}
+}
+
+// newMetricsPoller returns a metricsPoller that polls on the interval
+func newMetricsPoller(interval time.Duration) *metricsPoller {
+	return &metricsPoller{
+		interval: interval,
+		quit: make(chan struct{}),
+	}
+}
+
+// timeRPC calls the RPC method, recording how long it took and whether it failed
+func timeRPC(node, method string, call func() error) error {
+	started := time.Now()
+	err := call()
+	rpcLatency.WithLabelValues(node, method).Observe(time.Since(started).Seconds())
+	if err != nil {
+		rpcErrors.WithLabelValues(node, method).Inc()
+	}
+
+	return err
+}
+
+// pollNode updates the metrics of an RPC node. It returns false if the node couldn't be polled.
+func (p *metricsPoller) pollNode(id int, c *rpcclient.Client) bool {
+	node := strconv.Itoa(id)
+
+	var tips *soterjson.GetDAGTipsResult
+	err := timeRPC(node, "getdagtips", func() error {
+		var err error
+		tips, err = c.GetDAGTips()
+		return err
+	})
+	if err != nil {
+		return false
+	}
+	rpcTipMaxHeight.WithLabelValues(node).Set(float64(tips.MaxHeight))
+	rpcTipMinHeight.WithLabelValues(node).Set(float64(tips.MinHeight))
+	rpcBlocks.WithLabelValues(node).Set(float64(tips.BlkCount))
+	rpcTips.WithLabelValues(node).Set(float64(len(tips.Tips)))
+
+	var peers []soterjson.GetPeerInfoResult
+	err = timeRPC(node, "getpeerinfo", func() error {
+		var err error
+		peers, err = c.GetPeerInfo()
+		return err
+	})
+	if err != nil {
+		return false
+	}
+	inbound, outbound := sortPeers(peers)
+	rpcPeerCounts.WithLabelValues(node, "inbound").Set(float64(len(inbound)))
+	rpcPeerCounts.WithLabelValues(node, "outbound").Set(float64(len(outbound)))
+
+	return true
+}
+
+// poll updates the metrics of each RPC node
+func (p *metricsPoller) poll() {
+	for id, c := range clients {
+		up := 0.0
+		if p.pollNode(id, c) {
+			up = 1
+		}
+		rpcUp.WithLabelValues(strconv.Itoa(id)).Set(up)
+	}
+}
+
+// run polls the RPC nodes until the poller is stopped
+func (p *metricsPoller) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.poll()
+	for {
+		select {
+			case <-ticker.C:
+				p.poll()
+			case <-p.quit:
+				return
+		}
+	}
+}
+
+// Start polling in a new goroutine
+func (p *metricsPoller) Start() {
+	if atomic.AddInt32(&p.started, 1) != 1 {
+		return
+	}
+
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop polling
+func (p *metricsPoller) Stop() {
+	if atomic.AddInt32(&p.shutdown, 1) != 1 {
+		return
+	}
+
+	close(p.quit)
+	p.wg.Wait()
+}
+
+var (
+	censusNodesDesc = prometheus.NewDesc(metricsNamespace + "_census_nodes", "How many nodes are in the census, by status", []string{"status"}, nil)
+	censusVersionsDesc = prometheus.NewDesc(metricsNamespace + "_census_node_versions", "How many nodes in the census run each version", []string{"version"}, nil)
+	censusPollsDesc = prometheus.NewDesc(metricsNamespace + "_census_poll_duration_seconds", "How long census workers took to poll nodes", nil, nil)
+	censusPollFailuresDesc = prometheus.NewDesc(metricsNamespace + "_census_poll_failures_total", "How many census node polls failed", nil, nil)
+	censusWorkersDesc = prometheus.NewDesc(metricsNamespace + "_census_workers", "How many census workers are in each state", []string{"state"}, nil)
+	censusWorkerTargetDesc = prometheus.NewDesc(metricsNamespace + "_census_workers_target", "How many census workers the census is trying to keep running", nil, nil)
+	censusWorkerFailuresDesc = prometheus.NewDesc(metricsNamespace + "_census_worker_failures_total", "How many times census workers exited unexpectedly", nil, nil)
+	censusOverdueDesc = prometheus.NewDesc(metricsNamespace + "_census_overdue_nodes", "How many nodes are due for polling, but not being checked by a worker", nil, nil)
+)
+
+// censusCollector reads census metrics from the enumerator's in-memory state when scraped
+type censusCollector struct{}
+
+func (censusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- censusNodesDesc
+	ch <- censusVersionsDesc
+	ch <- censusPollsDesc
+	ch <- censusPollFailuresDesc
+	ch <- censusWorkersDesc
+	ch <- censusWorkerTargetDesc
+	ch <- censusWorkerFailuresDesc
+	ch <- censusOverdueDesc
+}
+
+func (censusCollector) Collect(ch chan<- prometheus.Metric) {
+	if e == nil {
+		return
+	}
+
+	// Count nodes the same way as the nodes table does
+	statuses := map[string]int{"online": 0, "offline": 0, "unknown": 0}
+	versions := make(map[string]int)
+	for _, n := range e.Nodes() {
+		r := soterdNodeRow{Online: n.Online, Stale: n.IsStale(e.Interval * 3)}
+		statuses[r.Status()]++
+		if len(n.Version) > 0 {
+			versions[n.Version]++
+		}
+	}
+	for status, count := range statuses {
+		ch <- prometheus.MustNewConstMetric(censusNodesDesc, prometheus.GaugeValue, float64(count), status)
+	}
+	for version, count := range versions {
+		ch <- prometheus.MustNewConstMetric(censusVersionsDesc, prometheus.GaugeValue, float64(count), version)
+	}
+
+	polls := e.PollStats()
+	buckets := make(map[float64]uint64)
+	for _, b := range census.PollBuckets {
+		buckets[b] = polls.Buckets[b]
+	}
+	ch <- prometheus.MustNewConstHistogram(censusPollsDesc, polls.Polls, polls.Seconds, buckets)
+	ch <- prometheus.MustNewConstMetric(censusPollFailuresDesc, prometheus.CounterValue, float64(polls.Failures))
+
+	ws := e.WorkerStatus()
+	ch <- prometheus.MustNewConstMetric(censusWorkersDesc, prometheus.GaugeValue, float64(ws.Running), "running")
+	ch <- prometheus.MustNewConstMetric(censusWorkersDesc, prometheus.GaugeValue, float64(ws.Restarting), "restarting")
+	ch <- prometheus.MustNewConstMetric(censusWorkerTargetDesc, prometheus.GaugeValue, float64(ws.Target))
+	ch <- prometheus.MustNewConstMetric(censusWorkerFailuresDesc, prometheus.CounterValue, float64(ws.Failures))
+	ch <- prometheus.MustNewConstMetric(censusOverdueDesc, prometheus.GaugeValue, float64(ws.Overdue))
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes through, for streaming responses like /events
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrumentHTTP wraps a handler to count requests and measure their latency, labelled by the DefaultServeMux
+// pattern that the request matches, so that paths like /block/<hash> don't each get their own series.
+func instrumentHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := http.DefaultServeMux.Handler(r)
+		if len(route) == 0 {
+			route = "none"
+		}
+
+		started := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		httpLatency.WithLabelValues(route, r.Method).Observe(time.Since(started).Seconds())
+	})
+}
@@ -7,6 +7,10 @@ package main
 import (
 	"fmt"
 	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/soteria-dag/soterdash/census"
@@ -20,6 +24,20 @@ import (
 const (
 	// How many generations from tips we'll render for RecentDagSvg
 	recentDagRange = int32(3)
+
+	// The most generations that are rendered at once on the dag page
+	dagFetchLimit = int32(100)
+
+	// How many mempool transactions we'll list on the mempool page
+	mempoolTxLimit = 500
+
+	// How many recent blocks we'll list on the propagation page
+	propagationRecentLimit = 50
+
+	// Warn about an autonomous system hosting more than this percentage of located census nodes
+	asnConcentrationPercent = 33.0
+	// The fewest nodes with a known ASN before we'll warn about concentration
+	asnConcentrationMinNodes = 5
 )
 
 // Represent block data that we're interested in rendering
@@ -32,6 +50,106 @@ type soterdBlock struct {
 	MerkleRoot   string
 	NextHashes   []string
 	Difficulty   float64
+	// How the block propagated across the RPC nodes, if we saw it arrive
+	Propagation *blockPropagation
+	// The results of checking the block's merkle root, proof of work, parents and timestamp
+	Integrity blockIntegrity
+}
+
+// How many propagation delays fall within a range
+type delayBucket struct {
+	Label string
+	// The upper bound of the range. The last bucket has no upper bound.
+	Max time.Duration
+	Count int
+}
+
+// Represents block propagation timing across the RPC nodes, that we're interested in rendering
+type soterdPropagation struct {
+	RPCNodes int
+	// How many recent blocks are tracked, and how many of them were seen by more than one RPC node
+	Blocks int
+	Measured int
+	// Percentiles of the delay between the first RPC node seeing a block, and each other RPC node seeing it
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+	Buckets []delayBucket
+	// SVG chart of the delay distribution
+	ChartSvg template.HTML
+	// The most recently seen blocks
+	Recent []blockPropagation
+}
+
+// Represents the alert rules and alerts, that we're interested in rendering
+type soterdAlerts struct {
+	Rules []alertRule
+	// Pending and firing alerts, and recently resolved ones
+	Active []alert
+	Recent []alert
+	Webhooks int
+	LastEvaluated time.Time
+}
+
+// Represents the history of blocks flipping between blue and red, that we're interested in rendering
+type soterdColoring struct {
+	RPCNodes int
+	// The RPC node the flips are shown for, or -1 for every RPC node
+	RPCNode int
+	Flips []coloringFlip
+}
+
+// NodeIDs returns the ids of the RPC nodes, for linking to their flips
+func (c *soterdColoring) NodeIDs() []int {
+	ids := make([]int, c.RPCNodes)
+	for i := range ids {
+		ids[i] = i
+	}
+	return ids
+}
+
+// Represents the verification of the RPC nodes' dag coloring, that we're interested in rendering
+type soterdVerification struct {
+	// The range of heights that were verified
+	MinHeight int32
+	MaxHeight int32
+	K int
+	AnchorDepth int32
+	Nodes []nodeVerification
+}
+
+// Mismatches returns how many mismatched blocks were found across the RPC nodes
+func (v *soterdVerification) Mismatches() int {
+	count := 0
+	for _, n := range v.Nodes {
+		count += len(n.Mismatches)
+	}
+	return count
+}
+
+// Represents the difficulty of a range of the dag, that we're interested in rendering
+type soterdDifficulty struct {
+	Network string
+	// The range of heights that were sampled
+	MinHeight int32
+	MaxHeight int32
+	Settings retargetSettings
+	Generations []generationDifficulty
+	Next nextTarget
+	// SVG chart of the difficulty and block interval of each generation
+	ChartSvg template.HTML
+}
+
+// Retargets returns the retarget points in the sampled range
+func (d *soterdDifficulty) Retargets() []generationDifficulty {
+	retargets := make([]generationDifficulty, 0)
+	for _, g := range d.Generations {
+		if g.Retarget {
+			retargets = append(retargets, g)
+		}
+	}
+	return retargets
 }
 
 // Represents census-enumerated node data that we're interested in rendering
@@ -42,27 +160,302 @@ type soterdNode struct {
 	Connections []*census.Node
 	LastChecked time.Time
 	Stale bool
+	// Network timing measurements between soterdash and the node
+	Latency census.Latency
+	// Where the node is located
+	Geo census.GeoInfo
+	// What census agents last reported about the node
+	Vantages []census.Vantage
+}
+
+// Represents a census-enumerated node as a row in the node table
+type soterdNodeRow struct {
+	Address string `json:"address"`
+	Version string `json:"version"`
+	Online bool `json:"online"`
+	Stale bool `json:"stale"`
+	Hops int `json:"hops"`
+	Connections int `json:"connections"`
+	LastChecked time.Time `json:"lastChecked"`
+	Country string `json:"country"`
+	CountryCode string `json:"countryCode"`
+	City string `json:"city"`
+	ASN uint `json:"asn"`
+	Organization string `json:"organization"`
+}
+
+// Represents a page of the census node table that we're interested in rendering
+type soterdNodeTable struct {
+	// The filters and sorting applied to the table
+	Query nodeQuery
+	// The rows on the current page
+	Rows []soterdNodeRow
+	// How many nodes matched the filters
+	Total int
+	// Pages are numbered starting from 1
+	Page int
+	Pages int
+	// How many census nodes run each version, most common first
+	Versions []versionCount
+	// Where census nodes are located
+	Geo geoDistribution
+}
+
+// Filtering, sorting and pagination options for the census node table
+type nodeQuery struct {
+	// Only include nodes whose address or version contain this text
+	Text string
+	// Only include nodes whose address is within this CIDR block
+	CIDR string
+	// The column to sort by, and if the sort order is descending
+	Sort string
+	Desc bool
+	Page int
+	PerPage int
+}
+
+// How many census nodes run a version
+type versionCount struct {
+	Version string
+	Count int
+	Percent float64
+}
+
+// How many census nodes are located in each country and autonomous system
+type geoDistribution struct {
+	// Nodes per country and per ASN, most common first
+	Countries []geoCount
+	ASNs []geoCount
+	// How many nodes couldn't be located
+	Unlocated int
+	// The ASNs that host too large a share of the network
+	Concentrated []geoCount
+}
+
+// How many census nodes are in a country or autonomous system
+type geoCount struct {
+	// A country code or ASN
+	Key string
+	// A country name or AS organization
+	Name string
+	Count int
+	Percent float64
+}
+
+// Represents census-enumerated network topology that we're interested in rendering
+type soterdTopology struct {
+	NodeCount int
+	EdgeCount int
+	// Node addresses of each connected component, largest first
+	Components [][]string
+	// How many nodes have each degree, ordered by degree
+	DegreeDistribution []degreeCount
+	// Nodes and connections that are single points of failure
+	ArticulationPoints []string
+	Bridges []census.Edge
+	Diameter int
+	// Nodes ordered by betweenness centrality, most central first
+	Centrality []nodeCentrality
+}
+
+// How many nodes in the census have a degree (number of connections)
+type degreeCount struct {
+	Degree int
+	Count int
+}
+
+// The betweenness centrality of a census node
+type nodeCentrality struct {
+	Address string
+	Degree int
+	Betweenness float64
+}
+
+// Represents the changes in the census between two points in time, that we're interested in rendering
+type soterdCensusDiff struct {
+	// The from and to times as they were requested, for re-filling the form
+	FromParam string
+	ToParam string
+	// The oldest and newest snapshots available, and how many there are
+	Oldest time.Time
+	Newest time.Time
+	SnapshotCount int
+	Diff *census.Diff
+	// SVG rendering of the node graph, with the changes highlighted
+	OverlaySvg template.HTML
+}
+
+// Represents an unconfirmed transaction in an RPC node's mempool
+type mempoolTx struct {
+	Hash string
+	Size int32
+	Vsize int32
+	Fee float64
+	// Fee paid per virtual byte, in nanoSoter
+	FeeRate int64
+	Added time.Time
+	Age time.Duration
+	// How many other mempool transactions this one depends on
+	Depends int
+}
+
+// How many mempool transactions have a fee rate within a range
+type feeRateBucket struct {
+	Label string
+	Min int64
+	Count int
+	Percent float64
+}
+
+// Which RPC nodes have a transaction in their mempool
+type mempoolPresence struct {
+	Hash string
+	// Indexed the same as mempoolComparison.Nodes
+	On []bool
+}
+
+// Represents transactions that are in some RPC nodes' mempools but not others
+type mempoolComparison struct {
+	Nodes []int
+	// How many transactions are in any of the mempools, and how many of them are in all of the mempools
+	Total int
+	Everywhere int
+	Partial []mempoolPresence
+}
+
+// Represents an RPC node's mempool, that we're interested in rendering
+type soterdMempool struct {
+	RPCNode int
+	// All of the RPC node ids, for switching between mempools
+	RPCNodes []int
+	Count int
+	TotalSize int64
+	TotalFee float64
+	FeeRates []feeRateBucket
+	// Transactions, with the highest fee rate first
+	Txs []mempoolTx
+	// If Txs was limited to mempoolTxLimit transactions
+	Truncated bool
+	Comparison *mempoolComparison
+}
+
+// Represents a transaction that we're interested in rendering
+type soterdTx struct {
+	Tx *soterjson.TxRawResult
+	// Which RPC node the transaction was found on, and its mempool entry when it's unconfirmed
+	RPCNode int
+	Mempool *soterjson.GetMempoolEntryResult
 }
 
 // Represent node data that we're interested in rendering
 type soterdRPCNode struct {
-	Id int
+	Id int `json:"id"`
 	// The dag net of the node (testnet, etc)
-	Net string
-	Version string
-	InboundPeers map[int32]*soterjson.GetPeerInfoResult
-	OutboundPeers map[int32]*soterjson.GetPeerInfoResult
-	InboundPeerCount int
-	OutboundPeerCount int
+	Net string `json:"net"`
+	Version string `json:"version"`
+	InboundPeers map[int32]*soterjson.GetPeerInfoResult `json:"inboundPeers"`
+	OutboundPeers map[int32]*soterjson.GetPeerInfoResult `json:"outboundPeers"`
+	InboundPeerCount int `json:"inboundPeerCount"`
+	OutboundPeerCount int `json:"outboundPeerCount"`
 	// Hashes of dag tips of node
-	Tips []string
+	Tips []string `json:"tips"`
 	// Hash of tips of node
-	VirtualHash string
-	MinHeight int32
-	MaxHeight int32
-	BlkCount uint32
+	VirtualHash string `json:"virtualHash"`
+	MinHeight int32 `json:"minHeight"`
+	MaxHeight int32 `json:"maxHeight"`
+	BlkCount uint32 `json:"blkCount"`
 	// SVG rendering of recent dag (MaxHeight - recentDagRange generations) to MaxHeight
-	RecentDagSvg template.HTML
+	RecentDagSvg template.HTML `json:"-"`
+	// Network traffic history of the node
+	Bandwidth soterdBandwidth `json:"-"`
+	// Why the node's details couldn't be fetched, when it's listed without them
+	Error string `json:"error,omitempty"`
+}
+
+// Represents an RPC node's network traffic history
+type soterdBandwidth struct {
+	// How many traffic samples are kept, and the time span they cover
+	Samples int
+	Span time.Duration
+	// The node's total traffic counters, as of the latest sample
+	BytesSent uint64
+	BytesRecv uint64
+	// SVG charts of total, inbound and outbound rates, and of the top talkers' rates
+	RatesSvg template.HTML
+	TalkersSvg template.HTML
+	TopTalkers []talker
+}
+
+// Represents a connection between a directly-connected RPC node and one of its peers, from the node's getpeerinfo
+type peerEdge struct {
+	// Address of the RPC node, and of the peer
+	From string
+	To string
+	// If the peer connected to the RPC node, rather than the other way around
+	Inbound bool
+	PingTime time.Duration
+	BytesSent uint64
+	BytesRecv uint64
+	// How long the connection has been up
+	Duration time.Duration
+}
+
+// Represents the graph of RPC nodes' peer connections, compared with the census graph
+type soterdPeerGraph struct {
+	// Addresses of the RPC nodes
+	RPCNodes []string
+	Edges []peerEdge
+	// Connections that only the RPC nodes' peer info shows, or only the census shows.
+	// The census connections are limited to ones involving an RPC node, since those are all that the peer info can see.
+	PeerOnly []census.Edge
+	CensusOnly []census.Edge
+	// SVG rendering of the peer graph
+	Svg template.HTML
+}
+
+// Represents a directly-connected RPC node's peer, from the node's getpeerinfo
+type soterdPeer struct {
+	// Which RPC node the peer is connected to
+	RPCNode int `json:"rpcNode"`
+	ID int32 `json:"id"`
+	Addr string `json:"addr"`
+	// The RPC node's address on the connection
+	AddrLocal string `json:"addrLocal"`
+	Services string `json:"services"`
+	UserAgent string `json:"userAgent"`
+	Version uint32 `json:"version"`
+	Inbound bool `json:"inbound"`
+	RelayTxes bool `json:"relayTxes"`
+	PingTime time.Duration `json:"pingTime"`
+	PingWait time.Duration `json:"pingWait"`
+	BytesSent uint64 `json:"bytesSent"`
+	BytesRecv uint64 `json:"bytesRecv"`
+	LastSend time.Time `json:"lastSend"`
+	LastRecv time.Time `json:"lastRecv"`
+	ConnTime time.Time `json:"connTime"`
+	TimeOffset int64 `json:"timeOffset"`
+	StartingHeight int32 `json:"startingHeight"`
+	CurrentHeight int32 `json:"currentHeight"`
+	BanScore int32 `json:"banScore"`
+	FeeFilter int64 `json:"feeFilter"`
+	SyncNode bool `json:"syncNode"`
+	// The peer's address in the census, if it's in the census
+	CensusAddr string `json:"censusAddr,omitempty"`
+}
+
+// Sorting options for an RPC node's peer table
+type peerQuery struct {
+	// Which RPC node's peers to show
+	RPCNode int
+	// The column to sort by, and if the sort order is descending
+	Sort string
+	Desc bool
+}
+
+// Represents an RPC node's peers as a table
+type soterdPeerTable struct {
+	Query peerQuery
+	Peers []soterdPeer
 }
 
 // sortPeers returns the number of **unique** peer connections
@@ -70,7 +463,9 @@ func sortPeers(peers []soterjson.GetPeerInfoResult) (map[int32]*soterjson.GetPee
 	inbound := make(map[int32]*soterjson.GetPeerInfoResult)
 	outbound := make(map[int32]*soterjson.GetPeerInfoResult)
 
-	for _, p := range peers {
+	for i := range peers {
+		// Take the address of the slice element rather than the loop variable, which is reused on each iteration
+		p := &peers[i]
 		var targetMap *map[int32]*soterjson.GetPeerInfoResult
 		if p.Inbound {
 			targetMap = &inbound
@@ -82,7 +477,7 @@ func sortPeers(peers []soterjson.GetPeerInfoResult) (map[int32]*soterjson.GetPee
 		if exists {
 			continue
 		}
-		(*targetMap)[p.ID] = &p
+		(*targetMap)[p.ID] = p
 	}
 
 	return inbound, outbound
@@ -92,17 +487,17 @@ func sortPeers(peers []soterjson.GetPeerInfoResult) (map[int32]*soterjson.GetPee
 func blockInfo(c *rpcclient.Client, hash string) (soterdBlock, error) {
 	h, err := chainhash.NewHashFromStr(hash)
 	if err != nil {
-		return soterdBlock{}, err
+		return soterdBlock{}, newHTTPError(http.StatusBadRequest, "invalid block hash '%s': %s", hash, err)
 	}
 
 	block, err := c.GetBlock(h)
 	if err != nil {
-		return soterdBlock{}, err
+		return soterdBlock{}, rpcError(err)
 	}
 
 	header, err := c.GetBlockHeaderVerbose(h)
 	if err != nil {
-		return soterdBlock{}, err
+		return soterdBlock{}, rpcError(err)
 	}
 
 	// NOTE(cedric): soterd node(s) we're connecting to need to be running a wallet service, in order for us to use
@@ -118,11 +513,17 @@ func blockInfo(c *rpcclient.Client, hash string) (soterdBlock, error) {
 		Difficulty: header.Difficulty,
 	}
 
+	if p, exists := propagation.get(h.String()); exists {
+		sb.Propagation = &p
+	}
+
+	sb.Integrity = newIntegrityChecker(c, activeNet).check(block, header.Height)
+
 	return sb, nil
 }
 
-// rpcNodeInfo returns a soterdRPCNode struct, which can be rendered
-func rpcNodeInfo(c *rpcclient.Client) (soterdRPCNode, error) {
+// rpcNodeSummary returns a soterdRPCNode struct without the dag rendering
+func rpcNodeSummary(c *rpcclient.Client) (soterdRPCNode, error) {
 	// Node network
 	net, err := c.GetCurrentNet()
 	if err != nil {
@@ -149,15 +550,43 @@ func rpcNodeInfo(c *rpcclient.Client) (soterdRPCNode, error) {
 		return soterdRPCNode{}, err
 	}
 
+	// Assemble the data into a soterdRPCNode model
+	n := soterdRPCNode{
+		Net: net.String(),
+		InboundPeers: inbound,
+		OutboundPeers: outbound,
+		InboundPeerCount: len(inbound),
+		OutboundPeerCount: len(outbound),
+		Tips: tips.Tips,
+		VirtualHash: tips.Hash,
+		MinHeight: tips.MinHeight,
+		MaxHeight: tips.MaxHeight,
+		BlkCount: tips.BlkCount,
+	}
+
+	if verExists {
+		n.Version = verInfo.VersionString
+	}
+
+	return n, nil
+}
+
+// rpcNodeInfo returns a soterdRPCNode struct, which can be rendered
+func rpcNodeInfo(c *rpcclient.Client) (soterdRPCNode, error) {
+	n, err := rpcNodeSummary(c)
+	if err != nil {
+		return soterdRPCNode{}, err
+	}
+
 	// Determine dag rendering range
-	minHeight := (tips.MaxHeight - recentDagRange)
+	minHeight := (n.MaxHeight - recentDagRange)
 	if minHeight < 0 {
 		minHeight = 0
 	}
 
 	// Dag svg rendering
 	nodes := []*rpcclient.Client{c}
-	dot, err := RenderDagsDot(nodes, minHeight, tips.MaxHeight)
+	dot, err := RenderDagsDot(nodes, minHeight, n.MaxHeight)
 	if err != nil {
 		return soterdRPCNode{}, err
 	}
@@ -169,35 +598,264 @@ func rpcNodeInfo(c *rpcclient.Client) (soterdRPCNode, error) {
 	if err != nil {
 		return soterdRPCNode{}, err
 	}
+	n.RecentDagSvg = template.HTML(svgEmbed)
 
-	// NOTE(cedric): Could call getnettotals to add to node Network info
-	// Assemble the data into a soterdRPCNode model
-	n := soterdRPCNode{
-		Net: net.String(),
-		InboundPeers: inbound,
-		OutboundPeers: outbound,
-		InboundPeerCount: len(inbound),
-		OutboundPeerCount: len(outbound),
-		Tips: tips.Tips,
-		VirtualHash: tips.Hash,
-		MinHeight: tips.MinHeight,
-		MaxHeight: tips.MaxHeight,
-		BlkCount: tips.BlkCount,
-		RecentDagSvg: template.HTML(svgEmbed),
+	return n, nil
+}
+
+// Status returns a description of the node's online status
+func (r *soterdNodeRow) Status() string {
+	if r.Stale {
+		return "unknown"
+	} else if r.Online {
+		return "online"
 	}
+	return "offline"
+}
 
-	if verExists {
-		n.Version = verInfo.VersionString
+// PrevPage returns the number of the page before the current one
+func (t *soterdNodeTable) PrevPage() int {
+	return t.Page - 1
+}
+
+// NextPage returns the number of the page after the current one
+func (t *soterdNodeTable) NextPage() int {
+	return t.Page + 1
+}
+
+// nodeRowLess compares two node table rows by the given column
+func nodeRowLess(a, b *soterdNodeRow, column string) bool {
+	switch column {
+	case "version":
+		return a.Version < b.Version
+	case "status":
+		return a.Status() < b.Status()
+	case "hops":
+		return a.Hops < b.Hops
+	case "connections":
+		return a.Connections < b.Connections
+	case "lastchecked":
+		return a.LastChecked.Before(b.LastChecked)
+	case "country":
+		return a.Country < b.Country
+	case "asn":
+		return a.ASN < b.ASN
+	default:
+		return a.Address < b.Address
+	}
+}
+
+// nodeRowMatches returns true if the node table row passes the filters
+func nodeRowMatches(r *soterdNodeRow, text string, block *net.IPNet) bool {
+	if len(text) > 0 {
+		text = strings.ToLower(text)
+		matched := false
+		for _, field := range []string{r.Address, r.Version, r.Country, r.City, r.Organization} {
+			if strings.Contains(strings.ToLower(field), text) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
 	}
 
-	return n, nil
+	if block != nil {
+		host, _, err := net.SplitHostPort(r.Address)
+		if err != nil {
+			host = r.Address
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil || !block.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nodeRows returns a row for each census-enumerated node that passes the query's filters, sorted by the query's column
+func nodeRows(q nodeQuery) ([]soterdNodeRow, error) {
+	var block *net.IPNet
+	if len(q.CIDR) > 0 {
+		var err error
+		_, block, err = net.ParseCIDR(q.CIDR)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows := make([]soterdNodeRow, 0)
+	for _, cNode := range e.Nodes() {
+		r := soterdNodeRow{
+			Address: cNode.Address,
+			Version: cNode.Version,
+			Online: cNode.Online,
+			Stale: cNode.IsStale(e.Interval * 3),
+			Hops: cNode.Hops,
+			Connections: len(cNode.Connections()),
+			LastChecked: cNode.LastChecked,
+			Country: cNode.Geo.Country,
+			CountryCode: cNode.Geo.CountryCode,
+			City: cNode.Geo.City,
+			ASN: cNode.Geo.ASN,
+			Organization: cNode.Geo.Organization,
+		}
+
+		if nodeRowMatches(&r, q.Text, block) {
+			rows = append(rows, r)
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if q.Desc {
+			return nodeRowLess(&rows[j], &rows[i], q.Sort)
+		}
+		return nodeRowLess(&rows[i], &rows[j], q.Sort)
+	})
+
+	return rows, nil
+}
+
+// versionDistribution returns how many census nodes run each version, most common first
+func versionDistribution() []versionCount {
+	nodes := e.Nodes()
+	counts := make(map[string]int)
+	for _, n := range nodes {
+		v := n.Version
+		if len(v) == 0 {
+			v = "unknown"
+		}
+		counts[v]++
+	}
+
+	versions := make([]versionCount, 0, len(counts))
+	for v, c := range counts {
+		versions = append(versions, versionCount{
+			Version: v,
+			Count: c,
+			Percent: float64(c) * 100 / float64(len(nodes)),
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Count == versions[j].Count {
+			return versions[i].Version < versions[j].Version
+		}
+		return versions[i].Count > versions[j].Count
+	})
+
+	return versions
+}
+
+// sortGeoCounts returns the counts as a slice, most common first
+func sortGeoCounts(counts map[string]*geoCount, total int) []geoCount {
+	sorted := make([]geoCount, 0, len(counts))
+	for _, c := range counts {
+		c.Percent = float64(c.Count) * 100 / float64(total)
+		sorted = append(sorted, *c)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count == sorted[j].Count {
+			return sorted[i].Key < sorted[j].Key
+		}
+		return sorted[i].Count > sorted[j].Count
+	})
+
+	return sorted
+}
+
+// locationDistribution returns how many census nodes are located in each country and autonomous system
+func locationDistribution() geoDistribution {
+	var d geoDistribution
+	countries := make(map[string]*geoCount)
+	asns := make(map[string]*geoCount)
+	located, withASN := 0, 0
+
+	for _, n := range e.Nodes() {
+		geo := n.Geo
+		if !geo.IsLocated() && !geo.HasASN() {
+			d.Unlocated++
+		}
+
+		if geo.IsLocated() {
+			located++
+			c, exists := countries[geo.CountryCode]
+			if !exists {
+				c = &geoCount{Key: geo.CountryCode, Name: geo.Country}
+				countries[geo.CountryCode] = c
+			}
+			c.Count++
+		}
+
+		if geo.HasASN() {
+			withASN++
+			key := fmt.Sprintf("AS%d", geo.ASN)
+			c, exists := asns[key]
+			if !exists {
+				c = &geoCount{Key: key, Name: geo.Organization}
+				asns[key] = c
+			}
+			c.Count++
+		}
+	}
+
+	d.Countries = sortGeoCounts(countries, located)
+	d.ASNs = sortGeoCounts(asns, withASN)
+
+	// A few nodes in the same AS is expected in small networks, so only warn once there's enough nodes to matter
+	if withASN >= asnConcentrationMinNodes {
+		for _, c := range d.ASNs {
+			if c.Percent > asnConcentrationPercent {
+				d.Concentrated = append(d.Concentrated, c)
+			}
+		}
+	}
+
+	return d
+}
+
+// nodeTable returns a page of the census node table, which can be rendered
+func nodeTable(q nodeQuery) (soterdNodeTable, error) {
+	rows, err := nodeRows(q)
+	if err != nil {
+		return soterdNodeTable{}, err
+	}
+
+	t := soterdNodeTable{
+		Query: q,
+		Total: len(rows),
+		Page: q.Page,
+		Pages: (len(rows) + q.PerPage - 1) / q.PerPage,
+		Versions: versionDistribution(),
+		Geo: locationDistribution(),
+	}
+
+	if t.Pages == 0 {
+		t.Pages = 1
+	}
+	if t.Page > t.Pages {
+		t.Page = t.Pages
+	}
+
+	start := (t.Page - 1) * q.PerPage
+	end := start + q.PerPage
+	if end > len(rows) {
+		end = len(rows)
+	}
+	t.Rows = rows[start:end]
+
+	return t, nil
 }
 
 // nodeInfo returns a soterdNode, which can be rendered
 func nodeInfo(address string) (soterdNode, error) {
 	cNode, exists := e.Get(address)
 	if !exists {
-		return soterdNode{}, fmt.Errorf("node with address %s not found in census", address)
+		return soterdNode{}, newHTTPError(http.StatusNotFound, "node with address %s not found in census", address)
 	}
 
 	n := soterdNode{
@@ -207,7 +865,800 @@ func nodeInfo(address string) (soterdNode, error) {
 		Connections: cNode.Connections(),
 		LastChecked: cNode.LastChecked,
 		Stale: cNode.IsStale(e.Interval * 3),
+		Latency: cNode.Latency,
+		Geo: cNode.Geo,
+		Vantages: cNode.Vantages(),
 	}
 
 	return n, nil
-}
\ No newline at end of file
+}
+
+// Partitioned returns true if the network is split into more than one connected component
+func (t *soterdTopology) Partitioned() bool {
+	return len(t.Components) > 1
+}
+
+// topologyInfo returns a soterdTopology, which can be rendered
+func topologyInfo() soterdTopology {
+	topo := census.Analyze(e.Nodes())
+
+	t := soterdTopology{
+		NodeCount: len(topo.Degree),
+		Components: topo.Components,
+		ArticulationPoints: topo.ArticulationPoints,
+		Bridges: topo.Bridges,
+		Diameter: topo.Diameter,
+	}
+
+	degreeSum := 0
+	for addr, degree := range topo.Degree {
+		degreeSum += degree
+		t.Centrality = append(t.Centrality, nodeCentrality{
+			Address: addr,
+			Degree: degree,
+			Betweenness: topo.Betweenness[addr],
+		})
+	}
+	// Each connection adds to the degree of both of its nodes
+	t.EdgeCount = degreeSum / 2
+
+	sort.Slice(t.Centrality, func(i, j int) bool {
+		if t.Centrality[i].Betweenness == t.Centrality[j].Betweenness {
+			return t.Centrality[i].Address < t.Centrality[j].Address
+		}
+		return t.Centrality[i].Betweenness > t.Centrality[j].Betweenness
+	})
+
+	for degree, count := range topo.DegreeDistribution {
+		t.DegreeDistribution = append(t.DegreeDistribution, degreeCount{Degree: degree, Count: count})
+	}
+	sort.Slice(t.DegreeDistribution, func(i, j int) bool {
+		return t.DegreeDistribution[i].Degree < t.DegreeDistribution[j].Degree
+	})
+
+	return t
+}
+
+// censusDiffInfo returns a soterdCensusDiff for the census between the two times, which can be rendered.
+// A zero to time compares against the census as it is now. When the from time is the default, and it's before the
+// oldest snapshot kept, the comparison is from the oldest snapshot instead.
+func censusDiffInfo(fromParam, toParam string, from, to time.Time, fromDefault bool) (soterdCensusDiff, error) {
+	d := soterdCensusDiff{
+		FromParam: fromParam,
+		ToParam: toParam,
+	}
+	d.Oldest, d.Newest, d.SnapshotCount = e.SnapshotSpan()
+
+	if d.SnapshotCount == 0 {
+		return d, newHTTPError(http.StatusNotFound, "no census snapshots have been taken yet")
+	}
+	if fromDefault && from.Before(d.Oldest) {
+		from = d.Oldest
+	}
+
+	fromSnap, exists := e.SnapshotAt(from)
+	if !exists {
+		return d, newHTTPError(http.StatusNotFound, "no census snapshot was kept from %s; the oldest is from %s",
+			from.Format(time.RFC3339), d.Oldest.Format(time.RFC3339))
+	}
+
+	toSnap := e.Snapshot()
+	if !to.IsZero() {
+		toSnap, exists = e.SnapshotAt(to)
+		if !exists {
+			return d, newHTTPError(http.StatusNotFound, "no census snapshot was kept from %s; the oldest is from %s",
+				to.Format(time.RFC3339), d.Oldest.Format(time.RFC3339))
+		}
+	}
+	d.Diff = census.Compare(fromSnap, toSnap)
+
+	dot, err := RenderDiffDot(d.Diff)
+	if err != nil {
+		return d, err
+	}
+	svg, err := soterutil.DotToSvg(dot)
+	if err != nil {
+		return d, err
+	}
+	svgEmbed, err := soterutil.StripSvgXmlDecl(svg)
+	if err != nil {
+		return d, err
+	}
+	d.OverlaySvg = template.HTML(svgEmbed)
+
+	return d, nil
+}
+
+// rpcNodeAddress returns the p2p address of the RPC node, normalized like census addresses
+func rpcNodeAddress(c *rpcclient.Client, peers []soterjson.GetPeerInfoResult) (string, error) {
+	listenAddrs, err := c.GetListenAddrs()
+	if err != nil {
+		return "", err
+	}
+	if len(listenAddrs.P2P) == 0 {
+		return "", fmt.Errorf("node isn't listening for p2p connections")
+	}
+
+	addr := listenAddrs.P2P[0]
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	// A node listening on all interfaces doesn't say which address its peers use to reach it,
+	// but its inbound peers know which of its addresses they connected to.
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		for _, p := range peers {
+			if !p.Inbound || len(p.AddrLocal) == 0 {
+				continue
+			}
+			localHost, _, err := net.SplitHostPort(p.AddrLocal)
+			if err == nil {
+				addr = net.JoinHostPort(localHost, port)
+				break
+			}
+		}
+	}
+
+	return census.NormalizeAddress(addr, port)
+}
+
+// peerGraphInfo returns a soterdPeerGraph of the connected RPC nodes' peers, which can be rendered
+func peerGraphInfo() (soterdPeerGraph, error) {
+	var g soterdPeerGraph
+	snap := e.Snapshot()
+
+	type rpcPeers struct {
+		addr string
+		peers []soterjson.GetPeerInfoResult
+	}
+	var rpcNodes []rpcPeers
+	isRPCNode := make(map[string]bool)
+
+	for _, c := range clients {
+		peers, err := c.GetPeerInfo()
+		if err != nil {
+			return g, err
+		}
+
+		addr, err := rpcNodeAddress(c, peers)
+		if err != nil {
+			return g, err
+		}
+
+		rpcNodes = append(rpcNodes, rpcPeers{addr: addr, peers: peers})
+		isRPCNode[addr] = true
+		g.RPCNodes = append(g.RPCNodes, addr)
+	}
+	sort.Strings(g.RPCNodes)
+
+	// Peers are matched up with census and RPC node addresses, so that the graph lines up with the census
+	addrs := append([]string{}, g.RPCNodes...)
+	known := make(map[string]bool)
+	for addr := range snap.Nodes {
+		addrs = append(addrs, addr)
+		known[addr] = true
+	}
+	for addr := range isRPCNode {
+		known[addr] = true
+	}
+	byHost := hostIndex(addrs)
+
+	resolve := func(p soterjson.GetPeerInfoResult) string {
+		if addr, found := matchPeerAddress(p.Addr, p.Inbound, known, byHost); found {
+			return addr
+		}
+		if addr, err := census.NormalizeAddress(p.Addr, ""); err == nil {
+			return addr
+		}
+		return p.Addr
+	}
+
+	seen := make(map[census.Edge]bool)
+	for _, rn := range rpcNodes {
+		for _, p := range rn.peers {
+			to := resolve(p)
+			g.Edges = append(g.Edges, peerEdge{
+				From: rn.addr,
+				To: to,
+				Inbound: p.Inbound,
+				PingTime: time.Duration(p.PingTime * float64(time.Microsecond)),
+				BytesSent: p.BytesSent,
+				BytesRecv: p.BytesRecv,
+				Duration: time.Since(time.Unix(p.ConnTime, 0)).Truncate(time.Second),
+			})
+			seen[census.NewEdge(rn.addr, to)] = true
+		}
+	}
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From == g.Edges[j].From {
+			return g.Edges[i].To < g.Edges[j].To
+		}
+		return g.Edges[i].From < g.Edges[j].From
+	})
+
+	for edge := range seen {
+		if !snap.Edges[edge] {
+			g.PeerOnly = append(g.PeerOnly, edge)
+		}
+	}
+	for edge := range snap.Edges {
+		if (isRPCNode[edge.A] || isRPCNode[edge.B]) && !seen[edge] {
+			g.CensusOnly = append(g.CensusOnly, edge)
+		}
+	}
+	census.SortEdges(g.PeerOnly)
+	census.SortEdges(g.CensusOnly)
+
+	dot, err := RenderPeerGraphDot(&g)
+	if err != nil {
+		return g, err
+	}
+	svg, err := soterutil.DotToSvg(dot)
+	if err != nil {
+		return g, err
+	}
+	svgEmbed, err := soterutil.StripSvgXmlDecl(svg)
+	if err != nil {
+		return g, err
+	}
+	g.Svg = template.HTML(svgEmbed)
+
+	return g, nil
+}
+
+// hostIndex maps each host to the first address on it, in sorted order
+func hostIndex(addrs []string) map[string]string {
+	sorted := append([]string{}, addrs...)
+	sort.Strings(sorted)
+
+	byHost := make(map[string]string)
+	for _, addr := range sorted {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		if _, exists := byHost[host]; !exists {
+			byHost[host] = addr
+		}
+	}
+
+	return byHost
+}
+
+// matchPeerAddress returns the known address of a peer, and if one was found.
+// Inbound peers are seen from an ephemeral source port rather than their listening address, so they're matched with a
+// known address on the same host.
+func matchPeerAddress(addr string, inbound bool, known map[string]bool, byHost map[string]string) (string, bool) {
+	addr, err := census.NormalizeAddress(addr, "")
+	if err != nil {
+		return "", false
+	}
+	if known[addr] {
+		return addr, true
+	}
+	if !inbound {
+		return "", false
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	match, exists := byHost[host]
+	return match, exists
+}
+
+// rpcClient returns the directly-connected RPC client with the id
+func rpcClient(id int) (*rpcclient.Client, error) {
+	if id < 0 || id >= len(clients) {
+		return nil, newHTTPError(http.StatusNotFound, "rpc node %d not found", id)
+	}
+
+	return clients[id], nil
+}
+
+// rpcPeers returns the peers of the RPC node with the id
+func rpcPeers(id int) ([]soterdPeer, error) {
+	c, err := rpcClient(id)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := c.GetPeerInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	known := make(map[string]bool)
+	for _, n := range e.Nodes() {
+		addrs = append(addrs, n.Address)
+		known[n.Address] = true
+	}
+	byHost := hostIndex(addrs)
+
+	// getpeerinfo can list the same peer more than once
+	inbound, outbound := sortPeers(info)
+	unique := make([]*soterjson.GetPeerInfoResult, 0, len(inbound) + len(outbound))
+	for _, p := range inbound {
+		unique = append(unique, p)
+	}
+	for _, p := range outbound {
+		unique = append(unique, p)
+	}
+
+	var peers []soterdPeer
+	for _, p := range unique {
+		peer := soterdPeer{
+			RPCNode: id,
+			ID: p.ID,
+			Addr: p.Addr,
+			AddrLocal: p.AddrLocal,
+			Services: p.Services,
+			UserAgent: p.SubVer,
+			Version: p.Version,
+			Inbound: p.Inbound,
+			RelayTxes: p.RelayTxes,
+			PingTime: time.Duration(p.PingTime * float64(time.Microsecond)),
+			PingWait: time.Duration(p.PingWait * float64(time.Microsecond)),
+			BytesSent: p.BytesSent,
+			BytesRecv: p.BytesRecv,
+			LastSend: time.Unix(p.LastSend, 0),
+			LastRecv: time.Unix(p.LastRecv, 0),
+			ConnTime: time.Unix(p.ConnTime, 0),
+			TimeOffset: p.TimeOffset,
+			StartingHeight: p.StartingHeight,
+			CurrentHeight: p.CurrentHeight,
+			BanScore: p.BanScore,
+			FeeFilter: p.FeeFilter,
+			SyncNode: p.SyncNode,
+		}
+		if addr, found := matchPeerAddress(p.Addr, p.Inbound, known, byHost); found {
+			peer.CensusAddr = addr
+		}
+
+		peers = append(peers, peer)
+	}
+
+	return peers, nil
+}
+
+// Direction returns if the peer connected to the RPC node (inbound), or the RPC node connected to the peer (outbound)
+func (p *soterdPeer) Direction() string {
+	if p.Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// peerLess returns true if peer a sorts before peer b by the column
+func peerLess(a, b *soterdPeer, column string) bool {
+	switch column {
+	case "addr":
+		return a.Addr < b.Addr
+	case "direction":
+		return a.Direction() < b.Direction()
+	case "useragent":
+		return a.UserAgent < b.UserAgent
+	case "ping":
+		return a.PingTime < b.PingTime
+	case "sent":
+		return a.BytesSent < b.BytesSent
+	case "recv":
+		return a.BytesRecv < b.BytesRecv
+	case "height":
+		return a.CurrentHeight < b.CurrentHeight
+	case "conntime":
+		return a.ConnTime.Before(b.ConnTime)
+	default:
+		return a.ID < b.ID
+	}
+}
+
+// peerTable returns a soterdPeerTable of the RPC node's peers, sorted according to the query
+func peerTable(q peerQuery) (soterdPeerTable, error) {
+	peers, err := rpcPeers(q.RPCNode)
+	if err != nil {
+		return soterdPeerTable{}, err
+	}
+
+	sort.SliceStable(peers, func(i, j int) bool {
+		if q.Desc {
+			return peerLess(&peers[j], &peers[i], q.Sort)
+		}
+		return peerLess(&peers[i], &peers[j], q.Sort)
+	})
+
+	return soterdPeerTable{Query: q, Peers: peers}, nil
+}
+
+// peerInfo returns the RPC node's peer with the id, which can be rendered
+func peerInfo(rpcNode int, id int32) (soterdPeer, error) {
+	peers, err := rpcPeers(rpcNode)
+	if err != nil {
+		return soterdPeer{}, err
+	}
+
+	for _, p := range peers {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+
+	return soterdPeer{}, newHTTPError(http.StatusNotFound, "peer %d of rpc node %d not found", id, rpcNode)
+}
+
+// bandwidthInfo returns a soterdBandwidth of the RPC node's traffic history, which can be rendered.
+// Charts are left empty until there's enough history to draw them.
+func bandwidthInfo(id int) (soterdBandwidth, error) {
+	var b soterdBandwidth
+
+	samples := bandwidth.get(id)
+	b.Samples = len(samples)
+	if len(samples) == 0 {
+		return b, nil
+	}
+
+	latest := samples[len(samples) - 1]
+	b.Span = latest.Time.Sub(samples[0].Time)
+	b.BytesSent = latest.BytesSent
+	b.BytesRecv = latest.BytesRecv
+	b.TopTalkers = topTalkers(samples, topTalkerCount)
+
+	// A line needs at least two points
+	rates := bandwidthRates(samples)
+	if len(rates) < 2 {
+		return b, nil
+	}
+
+	svg, err := RenderBandwidthSvg(rates)
+	if err != nil {
+		return b, err
+	}
+	b.RatesSvg = template.HTML(svg)
+
+	if len(b.TopTalkers) > 0 {
+		svg, err = RenderTalkersSvg(rates, b.TopTalkers)
+		if err != nil {
+			return b, err
+		}
+		b.TalkersSvg = template.HTML(svg)
+	}
+
+	return b, nil
+}
+
+// feeRateBuckets returns empty buckets for the mempool fee rate distribution, lowest fee rate first
+func feeRateBuckets() []feeRateBucket {
+	var buckets []feeRateBucket
+	bounds := []int64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+	for i, min := range bounds {
+		label := fmt.Sprintf("%d+", min)
+		if i + 1 < len(bounds) {
+			label = fmt.Sprintf("%d-%d", min, bounds[i + 1])
+		}
+		buckets = append(buckets, feeRateBucket{Label: label, Min: min})
+	}
+
+	return buckets
+}
+
+// mempoolInfo returns a soterdMempool of the RPC node's mempool, which can be rendered
+func mempoolInfo(id int) (soterdMempool, error) {
+	c, err := rpcClient(id)
+	if err != nil {
+		return soterdMempool{}, err
+	}
+
+	pool, err := c.GetRawMempoolVerbose()
+	if err != nil {
+		return soterdMempool{}, err
+	}
+
+	m := soterdMempool{
+		RPCNode: id,
+		Count: len(pool),
+		FeeRates: feeRateBuckets(),
+	}
+	for i := range clients {
+		m.RPCNodes = append(m.RPCNodes, i)
+	}
+
+	now := time.Now()
+	for hash, entry := range pool {
+		tx := mempoolTx{
+			Hash: hash,
+			Size: entry.Size,
+			Vsize: entry.Vsize,
+			Fee: entry.Fee,
+			Added: time.Unix(entry.Time, 0),
+			Depends: len(entry.Depends),
+		}
+		tx.Age = now.Sub(tx.Added).Truncate(time.Second)
+		if tx.Vsize == 0 {
+			tx.Vsize = tx.Size
+		}
+		if fee, err := soterutil.NewAmount(entry.Fee); err == nil && tx.Vsize > 0 {
+			tx.FeeRate = int64(fee) / int64(tx.Vsize)
+		}
+
+		m.TotalSize += int64(tx.Size)
+		m.TotalFee += tx.Fee
+		for i := len(m.FeeRates) - 1; i >= 0; i-- {
+			if tx.FeeRate >= m.FeeRates[i].Min {
+				m.FeeRates[i].Count++
+				break
+			}
+		}
+
+		m.Txs = append(m.Txs, tx)
+	}
+
+	for i := range m.FeeRates {
+		if m.Count > 0 {
+			m.FeeRates[i].Percent = float64(m.FeeRates[i].Count) / float64(m.Count) * 100
+		}
+	}
+
+	sort.Slice(m.Txs, func(i, j int) bool {
+		if m.Txs[i].FeeRate == m.Txs[j].FeeRate {
+			return m.Txs[i].Hash < m.Txs[j].Hash
+		}
+		return m.Txs[i].FeeRate > m.Txs[j].FeeRate
+	})
+	if len(m.Txs) > mempoolTxLimit {
+		m.Txs = m.Txs[:mempoolTxLimit]
+		m.Truncated = true
+	}
+
+	if len(clients) > 1 {
+		m.Comparison, err = compareMempools()
+		if err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+// compareMempools returns the transactions that are in some RPC nodes' mempools but not others
+func compareMempools() (*mempoolComparison, error) {
+	var cmp mempoolComparison
+	presence := make(map[string][]bool)
+
+	for id, c := range clients {
+		hashes, err := c.GetRawMempool()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get mempool of rpc node %d: %s", id, err)
+		}
+
+		cmp.Nodes = append(cmp.Nodes, id)
+		for _, h := range hashes {
+			on, exists := presence[h.String()]
+			if !exists {
+				on = make([]bool, len(clients))
+				presence[h.String()] = on
+			}
+			on[id] = true
+		}
+	}
+
+	cmp.Total = len(presence)
+	for hash, on := range presence {
+		everywhere := true
+		for _, present := range on {
+			if !present {
+				everywhere = false
+				break
+			}
+		}
+
+		if everywhere {
+			cmp.Everywhere++
+		} else {
+			cmp.Partial = append(cmp.Partial, mempoolPresence{Hash: hash, On: on})
+		}
+	}
+
+	sort.Slice(cmp.Partial, func(i, j int) bool {
+		return cmp.Partial[i].Hash < cmp.Partial[j].Hash
+	})
+
+	return &cmp, nil
+}
+
+// txInfo returns a soterdTx for the transaction, which can be rendered.
+// RPC nodes are asked in turn, since an unconfirmed transaction may only be in some of their mempools.
+func txInfo(hash string) (soterdTx, error) {
+	h, err := chainhash.NewHashFromStr(hash)
+	if err != nil {
+		return soterdTx{}, newHTTPError(http.StatusBadRequest, "invalid transaction hash '%s': %s", hash, err)
+	}
+
+	if len(clients) == 0 {
+		return soterdTx{}, newHTTPError(http.StatusServiceUnavailable, "no rpc nodes are connected")
+	}
+
+	for id, c := range clients {
+		tx, err := c.GetRawTransactionVerbose(h)
+		if err != nil {
+			continue
+		}
+
+		t := soterdTx{Tx: tx, RPCNode: id}
+		if len(tx.BlockHash) == 0 {
+			entry, err := c.GetMempoolEntry(hash)
+			if err == nil {
+				t.Mempool = entry
+			}
+		}
+
+		return t, nil
+	}
+
+	return soterdTx{}, newHTTPError(http.StatusNotFound, "transaction %s not found on any rpc node", hash)
+}
+
+// delayBuckets returns empty buckets for the propagation delay distribution, shortest delay first
+func delayBuckets() []delayBucket {
+	return []delayBucket{
+		{Label: "<10ms", Max: time.Millisecond * 10},
+		{Label: "<50ms", Max: time.Millisecond * 50},
+		{Label: "<100ms", Max: time.Millisecond * 100},
+		{Label: "<250ms", Max: time.Millisecond * 250},
+		{Label: "<500ms", Max: time.Millisecond * 500},
+		{Label: "<1s", Max: time.Second},
+		{Label: "<2s", Max: time.Second * 2},
+		{Label: "<5s", Max: time.Second * 5},
+		{Label: "5s+"},
+	}
+}
+
+// propagationInfo returns a soterdPropagation of the recent blocks, which can be rendered
+func propagationInfo() (soterdPropagation, error) {
+	blocks := propagation.recent()
+	p := soterdPropagation{
+		RPCNodes: len(clients),
+		Blocks: len(blocks),
+		Buckets: delayBuckets(),
+	}
+
+	var delays []time.Duration
+	for _, b := range blocks {
+		if len(b.Sightings) < 2 {
+			continue
+		}
+		p.Measured++
+
+		// The first sighting has no delay, by definition
+		for _, s := range b.Sightings[1:] {
+			delays = append(delays, s.Delay)
+
+			for i := range p.Buckets {
+				if p.Buckets[i].Max == 0 || s.Delay < p.Buckets[i].Max {
+					p.Buckets[i].Count++
+					break
+				}
+			}
+		}
+	}
+
+	if len(blocks) > propagationRecentLimit {
+		blocks = blocks[:propagationRecentLimit]
+	}
+	p.Recent = blocks
+
+	if len(delays) == 0 {
+		return p, nil
+	}
+
+	sort.Slice(delays, func(i, j int) bool {
+		return delays[i] < delays[j]
+	})
+	p.P50 = percentile(delays, 50)
+	p.P90 = percentile(delays, 90)
+	p.P99 = percentile(delays, 99)
+	p.Max = delays[len(delays) - 1]
+
+	svg, err := RenderDelaySvg(p.Buckets)
+	if err != nil {
+		return p, err
+	}
+	p.ChartSvg = template.HTML(svg)
+
+	return p, nil
+}
+
+// alertsInfo returns a soterdAlerts struct, which can be rendered
+func alertsInfo() (soterdAlerts, error) {
+	if alerts == nil {
+		return soterdAlerts{}, newHTTPError(http.StatusServiceUnavailable, "alerting isn't running")
+	}
+
+	rules, active, recent, evaluated := alerts.status()
+	return soterdAlerts{
+		Rules: rules,
+		Active: active,
+		Recent: recent,
+		Webhooks: len(alerts.webhooks),
+		LastEvaluated: evaluated,
+	}, nil
+}
+
+// coloringInfo returns a soterdColoring struct for the RPC node, or for every RPC node if id is negative
+func coloringInfo(id int) (soterdColoring, error) {
+	if id >= len(clients) {
+		return soterdColoring{}, newHTTPError(http.StatusNotFound, "rpc node %d not found", id)
+	}
+
+	return soterdColoring{
+		RPCNodes: len(clients),
+		RPCNode: id,
+		Flips: colorTracker.history(id),
+	}, nil
+}
+
+// verificationInfo returns the verification of each RPC node's dag coloring between the heights
+func verificationInfo(minHeight, maxHeight int32) (soterdVerification, error) {
+	if minHeight > maxHeight {
+		return soterdVerification{}, newHTTPError(http.StatusBadRequest, "min height %d is above max height %d", minHeight, maxHeight)
+	}
+
+	v := soterdVerification{
+		MinHeight: minHeight,
+		MaxHeight: maxHeight,
+		K: coloringK,
+		AnchorDepth: verifyAnchorDepth,
+	}
+
+	for _, c := range clients {
+		n, err := verifyColoring(c, coloringK, minHeight, maxHeight)
+		if err != nil {
+			n.Err = err.Error()
+		}
+		v.Nodes = append(v.Nodes, n)
+	}
+
+	return v, nil
+}
+
+// difficultyInfo returns a soterdDifficulty of the RPC node's blocks between the heights, and the target expected of
+// the block after its tips at the tip height
+func difficultyInfo(c *rpcclient.Client, minHeight, maxHeight, tipHeight int32) (soterdDifficulty, error) {
+	if minHeight > maxHeight {
+		return soterdDifficulty{}, newHTTPError(http.StatusBadRequest, "min height %d is above max height %d", minHeight, maxHeight)
+	}
+
+	d := soterdDifficulty{
+		Network: activeNet.Name,
+		MinHeight: minHeight,
+		MaxHeight: maxHeight,
+		Settings: newRetargetSettings(activeNet),
+	}
+
+	generations, err := sampleDifficulty(c, activeNet, minHeight, maxHeight)
+	if err != nil {
+		return d, rpcError(err)
+	}
+	d.Generations = generations
+
+	d.Next, err = calcNextTarget(c, activeNet, tipHeight, time.Now())
+	if err != nil {
+		return d, rpcError(err)
+	}
+
+	// A line needs at least two points
+	if len(generations) < 2 {
+		return d, nil
+	}
+
+	svg, err := RenderDifficultySvg(generations)
+	if err != nil {
+		return d, err
+	}
+	d.ChartSvg = template.HTML(svg)
+
+	return d, nil
+}
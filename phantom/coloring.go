@@ -0,0 +1,160 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package phantom
+
+import (
+	"sort"
+)
+
+const (
+	// The k value that soterd colors its dag with
+	DefaultK = 3
+
+	// How many generations away from a block that its past and future are followed when finding anticones.
+	// This matches soterd's edge horizon, which limits how much of the dag its coloring looks at.
+	Horizon = 200
+)
+
+// Colorer selects blue sets of a DAG's blocks independently of soterd, using Algorithm 3 of the PHANTOM paper with the
+// same horizon and tie-breaking that soterd uses.
+type Colorer struct {
+	dag *DAG
+	k int
+	horizon int
+
+	// Maps a block to the blue set of its past and itself
+	blueSets map[string]map[string]bool
+}
+
+// NewColorer returns a Colorer for the DAG and k value. The DAG shouldn't be changed while the Colorer is used.
+func NewColorer(d *DAG, k int) *Colorer {
+	return &Colorer{
+		dag: d,
+		k: k,
+		horizon: Horizon,
+		blueSets: make(map[string]map[string]bool),
+	}
+}
+
+// BlueSet returns the blue set of the block's past, plus the block itself. This is the coloring that soterd reports
+// for its dag, from its latest block.
+func (c *Colorer) BlueSet(hash string) map[string]bool {
+	if !c.dag.Contains(hash) {
+		return make(map[string]bool)
+	}
+
+	set, ok := c.blueSets[hash]
+	if !ok {
+		set = c.selectBlueSet(c.dag.Past(hash, c.horizon), c.dag.Parents(hash))
+		set[hash] = true
+		c.blueSets[hash] = set
+	}
+
+	return clone(set)
+}
+
+// VirtualBlueSet returns the blue set of the whole DAG, as seen from a virtual block whose parents are the DAG's tips
+func (c *Colorer) VirtualBlueSet() map[string]bool {
+	tips := c.dag.Tips()
+	// The virtual block's parents are one generation away from it
+	within := walk(tips, c.dag.Parents, c.horizon)
+
+	return c.selectBlueSet(within, tips)
+}
+
+// selectBlueSet returns the blue set of the blocks within, whose tips are given
+func (c *Colorer) selectBlueSet(within map[string]bool, tips []string) map[string]bool {
+	blue := make(map[string]bool)
+	if len(within) == 1 {
+		// The past of a child of the genesis block (or the lowest block of a DAG fetched for a range) is always blue
+		for hash := range within {
+			blue[hash] = true
+		}
+		return blue
+	}
+
+	// Tips are tried in sorted order, and a later tip's blue set only wins if it's larger
+	tips = append([]string(nil), tips...)
+	sort.Strings(tips)
+
+	for _, tip := range tips {
+		set := c.BlueSet(tip)
+
+		// Extend the tip's blue set with blocks in its anticone that have at most k blue blocks in their own
+		// anticone. Blocks are considered in sorted order, since each one added affects the ones after it.
+		for _, hash := range sortedKeys(c.dag.Anticone(tip, within, c.horizon)) {
+			if set[hash] {
+				continue
+			}
+
+			blueAnticone := 0
+			for n := range c.dag.Anticone(hash, within, c.horizon) {
+				if set[n] {
+					blueAnticone++
+				}
+			}
+			if blueAnticone <= c.k {
+				set[hash] = true
+			}
+		}
+
+		if len(set) > len(blue) {
+			blue = set
+		}
+	}
+
+	return blue
+}
+
+// Mismatch is a block whose reported coloring doesn't match the computed blue set
+type Mismatch struct {
+	Hash string
+	// The block wasn't in the reported coloring at all
+	Missing bool
+	ReportedBlue bool
+	ComputedBlue bool
+}
+
+// Compare returns a mismatch for each of the blocks whose reported coloring, which maps block hashes to whether they
+// are blue, doesn't match the blue set. Mismatches are returned in the same order as blocks.
+func Compare(blocks []string, blue map[string]bool, reported map[string]bool) []Mismatch {
+	mismatches := make([]Mismatch, 0)
+	for _, hash := range blocks {
+		isBlue, ok := reported[hash]
+		if ok && isBlue == blue[hash] {
+			continue
+		}
+
+		mismatches = append(mismatches, Mismatch{
+			Hash: hash,
+			Missing: !ok,
+			ReportedBlue: isBlue,
+			ComputedBlue: blue[hash],
+		})
+	}
+
+	return mismatches
+}
+
+// clone returns a copy of the set
+func clone(set map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(set))
+	for k, v := range set {
+		c[k] = v
+	}
+
+	return c
+}
+
+// sortedKeys returns the set's members, sorted
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package phantom
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// edges describe a synthetic dag, as blocks and their parents
+type edges []struct {
+	hash string
+	parents []string
+}
+
+// build returns a DAG of the blocks
+func (e edges) build() *DAG {
+	d := NewDAG()
+	for _, b := range e {
+		d.Add(b.hash, b.parents)
+	}
+
+	return d
+}
+
+// Figure 3 of the PHANTOM paper
+var figure3 = edges{
+	{hash: "GENESIS"},
+	{hash: "B", parents: []string{"GENESIS"}},
+	{hash: "C", parents: []string{"GENESIS"}},
+	{hash: "D", parents: []string{"GENESIS"}},
+	{hash: "E", parents: []string{"GENESIS"}},
+	{hash: "F", parents: []string{"B", "C"}},
+	{hash: "H", parents: []string{"C", "D", "E"}},
+	{hash: "I", parents: []string{"E"}},
+	{hash: "J", parents: []string{"F", "H"}},
+	{hash: "K", parents: []string{"B", "H", "I"}},
+	{hash: "L", parents: []string{"D", "I"}},
+	{hash: "M", parents: []string{"F", "K"}},
+}
+
+// Figure 4 of the PHANTOM paper
+var figure4 = edges{
+	{hash: "GENESIS"},
+	{hash: "B", parents: []string{"GENESIS"}},
+	{hash: "C", parents: []string{"GENESIS"}},
+	{hash: "D", parents: []string{"GENESIS"}},
+	{hash: "E", parents: []string{"GENESIS"}},
+	{hash: "F", parents: []string{"B", "C"}},
+	{hash: "H", parents: []string{"E"}},
+	{hash: "I", parents: []string{"C", "D"}},
+	{hash: "J", parents: []string{"D", "F"}},
+	{hash: "K", parents: []string{"E", "I", "J"}},
+	{hash: "L", parents: []string{"F"}},
+	{hash: "M", parents: []string{"K", "L"}},
+	{hash: "N", parents: []string{"D", "H"}},
+	{hash: "O", parents: []string{"K"}},
+	{hash: "P", parents: []string{"K"}},
+	{hash: "Q", parents: []string{"N"}},
+	{hash: "R", parents: []string{"N", "O", "P"}},
+	{hash: "S", parents: []string{"Q"}},
+	{hash: "T", parents: []string{"S"}},
+	{hash: "U", parents: []string{"T"}},
+}
+
+// chain returns edges for a chain of n blocks on top of GENESIS
+func chain(n int) edges {
+	e := edges{{hash: "GENESIS"}}
+	prev := "GENESIS"
+	for i := 0; i < n; i++ {
+		hash := fmt.Sprintf("A%03d", i)
+		e = append(e, struct {
+			hash string
+			parents []string
+		}{hash: hash, parents: []string{prev}})
+		prev = hash
+	}
+
+	return e
+}
+
+func TestVirtualBlueSet(t *testing.T) {
+	tests := []struct {
+		name string
+		dag edges
+		k int
+		want []string
+	}{
+		{
+			name: "genesis only",
+			dag: edges{{hash: "GENESIS"}},
+			k: 3,
+			want: []string{"GENESIS"},
+		},
+		{
+			name: "figure 3, k = 0",
+			dag: figure3,
+			k: 0,
+			want: []string{"C", "GENESIS", "H", "K", "M"},
+		},
+		{
+			name: "figure 3, k = 3",
+			dag: figure3,
+			k: 3,
+			want: []string{"B", "C", "D", "F", "GENESIS", "H", "J", "K", "M"},
+		},
+		{
+			name: "figure 4, k = 3",
+			dag: figure4,
+			k: 3,
+			want: []string{"B", "C", "D", "E", "F", "GENESIS", "I", "J", "K", "M", "O", "P", "R"},
+		},
+		{
+			// Two blocks mined in parallel have only each other in their anticone, so with k = 1 both are blue
+			name: "parallel pair, k = 1",
+			dag: edges{
+				{hash: "GENESIS"},
+				{hash: "A", parents: []string{"GENESIS"}},
+				{hash: "B", parents: []string{"GENESIS"}},
+				{hash: "C", parents: []string{"A", "B"}},
+			},
+			k: 1,
+			want: []string{"A", "B", "C", "GENESIS"},
+		},
+		{
+			// With k = 0, only one of the parallel blocks is blue. Ties go to the first tip in sorted order.
+			name: "parallel pair, k = 0",
+			dag: edges{
+				{hash: "GENESIS"},
+				{hash: "A", parents: []string{"GENESIS"}},
+				{hash: "B", parents: []string{"GENESIS"}},
+			},
+			k: 0,
+			want: []string{"A", "GENESIS"},
+		},
+	}
+
+	for _, test := range tests {
+		c := NewColorer(test.dag.build(), test.k)
+		got := sortedKeys(c.VirtualBlueSet())
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got blue set %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBlueSet(t *testing.T) {
+	c := NewColorer(figure3.build(), 3)
+
+	// The blue set of a block includes the block itself, and nothing outside of its past. I is red because more than k
+	// of the blocks in its anticone (B, C, D and H) are blue.
+	got := sortedKeys(c.BlueSet("K"))
+	want := []string{"B", "C", "D", "E", "GENESIS", "H", "K"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got blue set of K %v, want %v", got, want)
+	}
+
+	got = sortedKeys(c.BlueSet("GENESIS"))
+	want = []string{"GENESIS"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got blue set of GENESIS %v, want %v", got, want)
+	}
+
+	got = sortedKeys(c.BlueSet("missing"))
+	if len(got) != 0 {
+		t.Errorf("got blue set of a missing block %v, want none", got)
+	}
+}
+
+func TestChainBlueSet(t *testing.T) {
+	// Every block of a chain is blue, even when it's longer than the horizon, because each block's blue set extends
+	// its parent's
+	e := chain(Horizon + 50)
+	c := NewColorer(e.build(), 0)
+
+	got := c.VirtualBlueSet()
+	if len(got) != len(e) {
+		t.Errorf("got %d blue blocks, want %d", len(got), len(e))
+	}
+
+	tip := e[len(e)-1].hash
+	if !got[tip] {
+		t.Errorf("tip %s isn't blue", tip)
+	}
+
+	if blue := c.BlueSet(tip); len(blue) != len(e) {
+		t.Errorf("got %d blocks in the blue set of %s, want %d", len(blue), tip, len(e))
+	}
+}
+
+func TestPartialDAG(t *testing.T) {
+	// Parents outside of the dag are ignored, so the lowest blocks of a fetched range become its roots
+	d := NewDAG()
+	d.Add("F", []string{"B", "C"})
+	d.Add("H", []string{"C", "D", "E"})
+	d.Add("J", []string{"F", "H"})
+
+	if got := d.Parents("F"); len(got) != 0 {
+		t.Errorf("got parents of F %v, want none", got)
+	}
+	if got, want := d.Tips(), []string{"J"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got tips %v, want %v", got, want)
+	}
+
+	c := NewColorer(d, 0)
+	got := sortedKeys(c.BlueSet("J"))
+	want := []string{"F", "J"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got blue set of J %v, want %v", got, want)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	blue := map[string]bool{"A": true, "B": true}
+	reported := map[string]bool{"A": true, "B": false, "C": true, "D": false}
+
+	got := Compare([]string{"A", "B", "C", "D", "E"}, blue, reported)
+	want := []Mismatch{
+		{Hash: "B", ReportedBlue: false, ComputedBlue: true},
+		{Hash: "C", ReportedBlue: true, ComputedBlue: false},
+		{Hash: "E", Missing: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got mismatches %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package phantom
+
+import (
+	"sort"
+)
+
+// DAG is a set of blocks, identified by hash, and the edges to their parents.
+// Parents that aren't in the DAG are ignored, so a DAG built from a range of heights has the blocks at the bottom of
+// the range as its roots.
+type DAG struct {
+	parents map[string][]string
+	// Maps a block to the blocks that name it as a parent, which may be added before the block itself
+	children map[string][]string
+}
+
+// NewDAG returns an empty DAG
+func NewDAG() *DAG {
+	return &DAG{
+		parents: make(map[string][]string),
+		children: make(map[string][]string),
+	}
+}
+
+// Add adds a block with the given parents. Adding a block that's already in the DAG does nothing.
+func (d *DAG) Add(hash string, parents []string) {
+	if d.Contains(hash) {
+		return
+	}
+
+	ps := make([]string, 0, len(parents))
+	seen := make(map[string]bool)
+	for _, p := range parents {
+		if seen[p] || p == hash {
+			continue
+		}
+		seen[p] = true
+		ps = append(ps, p)
+		d.children[p] = append(d.children[p], hash)
+	}
+	sort.Strings(ps)
+
+	d.parents[hash] = ps
+}
+
+// Contains returns true if the block is in the DAG
+func (d *DAG) Contains(hash string) bool {
+	_, ok := d.parents[hash]
+	return ok
+}
+
+// Size returns how many blocks are in the DAG
+func (d *DAG) Size() int {
+	return len(d.parents)
+}
+
+// Blocks returns the hashes of the blocks in the DAG, sorted
+func (d *DAG) Blocks() []string {
+	blocks := make([]string, 0, len(d.parents))
+	for hash := range d.parents {
+		blocks = append(blocks, hash)
+	}
+	sort.Strings(blocks)
+
+	return blocks
+}
+
+// Parents returns the block's parents that are in the DAG, sorted
+func (d *DAG) Parents(hash string) []string {
+	parents := make([]string, 0)
+	for _, p := range d.parents[hash] {
+		if d.Contains(p) {
+			parents = append(parents, p)
+		}
+	}
+
+	return parents
+}
+
+// Children returns the blocks in the DAG that have the block as a parent, sorted
+func (d *DAG) Children(hash string) []string {
+	children := make([]string, 0)
+	for _, c := range d.children[hash] {
+		if d.Contains(c) {
+			children = append(children, c)
+		}
+	}
+	sort.Strings(children)
+
+	return children
+}
+
+// Tips returns the blocks that have no children, sorted
+func (d *DAG) Tips() []string {
+	tips := make([]string, 0)
+	for _, hash := range d.Blocks() {
+		if len(d.Children(hash)) == 0 {
+			tips = append(tips, hash)
+		}
+	}
+
+	return tips
+}
+
+// walk returns the blocks reachable from start by repeatedly following next, up to horizon steps away.
+// The start blocks are one step away.
+func walk(start []string, next func(string) []string, horizon int) map[string]bool {
+	type step struct {
+		hash string
+		distance int
+	}
+
+	found := make(map[string]bool)
+	todo := make([]step, 0, len(start))
+	for _, hash := range start {
+		todo = append(todo, step{hash: hash, distance: 1})
+	}
+
+	// Breadth-first, so blocks are first reached at their shortest distance
+	for len(todo) > 0 {
+		s := todo[0]
+		todo = todo[1:]
+
+		if found[s.hash] {
+			continue
+		}
+		found[s.hash] = true
+
+		if s.distance+1 > horizon {
+			continue
+		}
+		for _, n := range next(s.hash) {
+			if !found[n] {
+				todo = append(todo, step{hash: n, distance: s.distance + 1})
+			}
+		}
+	}
+
+	return found
+}
+
+// Past returns the blocks in the past of the block, up to horizon generations back
+func (d *DAG) Past(hash string, horizon int) map[string]bool {
+	return walk(d.Parents(hash), d.Parents, horizon)
+}
+
+// Future returns the blocks in the future of the block, up to horizon generations forward
+func (d *DAG) Future(hash string, horizon int) map[string]bool {
+	return walk(d.Children(hash), d.Children, horizon)
+}
+
+// Anticone returns the blocks of within that are neither in the past nor the future of the block, looking up to
+// horizon generations away from it. The block itself isn't part of its anticone.
+func (d *DAG) Anticone(hash string, within map[string]bool, horizon int) map[string]bool {
+	past := d.Past(hash, horizon)
+	future := d.Future(hash, horizon)
+
+	anticone := make(map[string]bool)
+	for n := range within {
+		if n != hash && !past[n] && !future[n] {
+			anticone[n] = true
+		}
+	}
+
+	return anticone
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// How many recent blocks we keep propagation timing for
+	propagationBlockLimit = 1000
+)
+
+// When an RPC node first notified us of a block
+type blockSighting struct {
+	RPCNode int `json:"rpcNode"`
+	Seen time.Time `json:"seen"`
+	// How long after the first RPC node this one saw the block
+	Delay time.Duration `json:"delay"`
+}
+
+// Represents how a block propagated across the RPC nodes
+type blockPropagation struct {
+	Hash string `json:"hash"`
+	Height int32 `json:"height"`
+	// Sightings of the block, earliest first
+	Sightings []blockSighting `json:"sightings"`
+}
+
+// Spread returns how long the block took to reach every RPC node that has seen it
+func (b *blockPropagation) Spread() time.Duration {
+	if len(b.Sightings) == 0 {
+		return 0
+	}
+	return b.Sightings[len(b.Sightings) - 1].Delay
+}
+
+// propagationTracker records when each RPC node first saw each recent block.
+// Times are taken when the block notification arrives, so they're all measured by the same clock.
+type propagationTracker struct {
+	// Maps block hash to its propagation
+	blocks map[string]*blockPropagation
+
+	// Block hashes in the order they were first seen, oldest first
+	order []string
+
+	// How many blocks to keep
+	limit int
+
+	lock sync.RWMutex
+}
+
+// newPropagationTracker returns a propagationTracker that keeps the most recent blocks, up to the limit
+func newPropagationTracker(limit int) *propagationTracker {
+	return &propagationTracker{
+		blocks: make(map[string]*blockPropagation),
+		limit: limit,
+	}
+}
+
+// seen records that the RPC node saw the block at the time. Only the first sighting by each RPC node is kept.
+func (t *propagationTracker) seen(id int, hash string, height int32, at time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	b, exists := t.blocks[hash]
+	if !exists {
+		b = &blockPropagation{Hash: hash, Height: height}
+		t.blocks[hash] = b
+		t.order = append(t.order, hash)
+
+		for len(t.order) > t.limit {
+			delete(t.blocks, t.order[0])
+			t.order = t.order[1:]
+		}
+	}
+
+	for _, s := range b.Sightings {
+		if s.RPCNode == id {
+			return
+		}
+	}
+
+	b.Sightings = append(b.Sightings, blockSighting{RPCNode: id, Seen: at})
+	sort.Slice(b.Sightings, func(i, j int) bool {
+		return b.Sightings[i].Seen.Before(b.Sightings[j].Seen)
+	})
+	first := b.Sightings[0].Seen
+	for i := range b.Sightings {
+		b.Sightings[i].Delay = b.Sightings[i].Seen.Sub(first)
+	}
+}
+
+// get returns the propagation of the block, and if it was found
+func (t *propagationTracker) get(hash string) (blockPropagation, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	b, exists := t.blocks[hash]
+	if !exists {
+		return blockPropagation{}, false
+	}
+
+	cp := *b
+	cp.Sightings = append([]blockSighting{}, b.Sightings...)
+	return cp, true
+}
+
+// recent returns the propagation of the recent blocks, most recently seen first
+func (t *propagationTracker) recent() []blockPropagation {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	var blocks []blockPropagation
+	for i := len(t.order) - 1; i >= 0; i-- {
+		b := t.blocks[t.order[i]]
+		cp := *b
+		cp.Sightings = append([]blockSighting{}, b.Sightings...)
+		blocks = append(blocks, cp)
+	}
+
+	return blocks
+}
+
+// percentile returns the value at the percentile (0-100) of the sorted durations, using the nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+
+	return sorted[rank - 1]
+}
@@ -6,13 +6,24 @@ package main
 
 import (
 	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/wcharczuk/go-chart"
 
+	"github.com/soteria-dag/soterdash/census"
 	"github.com/soteria-dag/soterd/rpcclient"
+	"github.com/soteria-dag/soterd/soterjson"
 	"github.com/soteria-dag/soterd/wire"
 )
 
@@ -25,6 +36,9 @@ var (
 	// Read templates from templates dir
 	templates = template.Must(template.ParseGlob("templates/*.tmpl"))
 
+	// The 'brand' name used in the navbar
+	brand = "soterdash"
+
 	// The format used for graphviz color codes
 	hexColor = "#%x%x%x"
 
@@ -32,6 +46,20 @@ var (
 	green = color(0, 217, 101)
 	orange = color(255, 191, 0)
 	gray = color(185, 195, 198)
+	red = color(220, 53, 69)
+
+	// The outline color of dag blocks whose coloring has flipped between blue and red
+	flippedColor = red
+)
+
+const (
+	// Node graph coloring modes
+	// Color nodes by their online status
+	colorModeStatus = "status"
+	// Color nodes by the network partition (connected component) they're in
+	colorModePartition = "partition"
+	// Color nodes by their ping latency, from green (fastest) to red (slowest)
+	colorModeLatency = "latency"
 )
 
 type dagRange struct {
@@ -45,6 +73,27 @@ func color(r, g, b int) string {
 	return fmt.Sprintf(hexColor, []byte{uint8(r)}, []byte{uint8(g)}, []byte{uint8(b)})
 }
 
+// scaleColor returns a color on a green-orange-red scale in graphviz format, for a fraction between 0 (green) and 1 (red)
+func scaleColor(frac float64) string {
+	if frac < 0 {
+		frac = 0
+	}
+	if frac > 1 {
+		frac = 1
+	}
+
+	// Interpolate between green and orange for the first half of the scale, and orange and red for the second half
+	lerp := func(a, b int, f float64) int {
+		return a + int(float64(b - a) * f)
+	}
+	if frac < 0.5 {
+		f := frac * 2
+		return color(lerp(0, 255, f), lerp(217, 191, f), lerp(101, 0, f))
+	}
+	f := (frac - 0.5) * 2
+	return color(lerp(255, 220, f), lerp(191, 53, f), lerp(0, 69, f))
+}
+
 // colorPicker picks a color based on the input value and returns a string in the graphviz format:
 // #rrggbb, where rr is 2 hex characters for red, gg is 2 hex characters for green, bb is 2 hex characters for blue.
 func colorPicker (v int) string {
@@ -76,69 +125,200 @@ func stylePicker (solid, fill bool) string {
 
 }
 
-// renderHTML renders the given template text into the response, with optional template data
-func renderHTML(w http.ResponseWriter, tmpl string, data interface{}) {
+// htmlPage is an HTML page being rendered. The body is rendered into a buffer, and only written to the response in the
+// layout once the whole page has rendered, so that an error partway through can still be responded to with an error page.
+type htmlPage struct {
+	Title string
+	// The 'brand' name used in the navbar
+	Brand string
+
+	body bytes.Buffer
+
+	// The first error encountered while rendering. Nothing more is rendered after an error.
+	err error
+}
+
+// httpError is an error with the HTTP status that it should be responded to with
+type httpError struct {
+	Status int `json:"status"`
+	Message string `json:"message"`
+}
+
+func (e *httpError) Error() string {
+	return e.Message
+}
+
+// newHTTPError returns an httpError with the status, and a formatted message
+func newHTTPError(status int, format string, a ...interface{}) *httpError {
+	return &httpError{Status: status, Message: fmt.Sprintf(format, a...)}
+}
+
+// rpcError returns an httpError for a failed RPC call. Errors for blocks, heights or transactions the RPC node doesn't
+// have are reported as not found, and anything else as a bad gateway. An error that's already an httpError is returned
+// as it is.
+func rpcError(err error) *httpError {
+	if he, ok := err.(*httpError); ok {
+		return he
+	}
+
+	if rpcErr, ok := err.(*soterjson.RPCError); ok {
+		switch rpcErr.Code {
+		case soterjson.ErrRPCBlockNotFound, soterjson.ErrRPCOutOfRange, soterjson.ErrRPCInvalidParameter:
+			return newHTTPError(http.StatusNotFound, "%s", rpcErr.Message)
+		}
+	}
+	return newHTTPError(http.StatusBadGateway, "rpc node request failed: %s", err)
+}
+
+// errStatus returns the HTTP status for the error. Errors that aren't an httpError are internal server errors.
+func errStatus(err error) int {
+	if he, ok := err.(*httpError); ok {
+		return he.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// newHTMLPage returns an empty page with the title
+func newHTMLPage(title string) *htmlPage {
+	return &htmlPage{
+		Title: title,
+		Brand: brand,
+	}
+}
+
+// fail records an error that stops the page from rendering
+func (p *htmlPage) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// Body returns the rendered page body, for the layout
+func (p *htmlPage) Body() template.HTML {
+	return template.HTML(p.body.String())
+}
+
+// renderHTML renders the given template text into the page, with optional template data
+func renderHTML(p *htmlPage, tmpl string, data interface{}) {
+	if p.err != nil {
+		return
+	}
+
 	t := template.New("htmlElem")
 	t, err := t.Parse(tmpl)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		p.fail(err)
+		return
+	}
+
+	p.fail(t.Execute(&p.body, data))
+}
+
+// renderHTMLTmpl renders the template from the file into the page
+func renderHTMLTmpl(p *htmlPage, name string, data interface{}) {
+	if p.err != nil {
+		return
 	}
 
-	err = t.Execute(w, data)
+	p.fail(templates.ExecuteTemplate(&p.body, name, data))
+}
+
+// renderHTMLLayout renders the page in the layout, and writes it in the response with the status
+func renderHTMLLayout(w http.ResponseWriter, p *htmlPage, status int) error {
+	var buf bytes.Buffer
+	err := templates.ExecuteTemplate(&buf, "layout.tmpl", p)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
 	}
+
+	setContentType(w, "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
 }
 
-// renderHTMLErr renders the error in the response
-func renderHTMLErr(w http.ResponseWriter, err error) {
+// writeHTMLPage writes the rendered page in the response, or an error page if rendering failed
+func writeHTMLPage(w http.ResponseWriter, p *htmlPage) {
+	if p.err != nil {
+		renderHTMLErr(w, p.err)
+		return
+	}
+
+	err := renderHTMLLayout(w, p, http.StatusOK)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderHTMLErr(w, err)
 	}
 }
 
-// renderHTMLTmpl renders the template from the file in the response
-func renderHTMLTmpl(w http.ResponseWriter, name string, data interface{}) {
-	err := templates.ExecuteTemplate(w, name, data)
+// renderJSON renders the data as JSON in the response
+func renderJSON(w http.ResponseWriter, data interface{}) {
+	setContentType(w, "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-// renderHTMLOpen renders the <html> element's opening tag in the response
-func renderHTMLOpen(w http.ResponseWriter) {
-	setContentType(w, "text/html")
-	renderHTML(w, "<!DOCTYPE html>", nil)
-	renderHTML(w, "<html>", nil)
-}
+// renderCSV renders the records as CSV in the response, as a file download with the given name
+func renderCSV(w http.ResponseWriter, filename string, records [][]string) {
+	setContentType(w, "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
 
-// renderHTMLClose renders the </html> element's closing tag in the response
-func renderHTMLClose(w http.ResponseWriter) {
-	renderHTML(w, "</html>", nil)
+	cw := csv.NewWriter(w)
+	err := cw.WriteAll(records)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
-// renderHTMLBodyOpen renders the <body> element's opening tag in the response
-func renderHTMLBodyOpen(w http.ResponseWriter) {
-	renderHTML(w, "<body>", nil)
-}
+// renderHTMLErr renders an error page for the error in the response, with the error's HTTP status
+func renderHTMLErr(w http.ResponseWriter, err error) {
+	if err == nil {
+		return
+	}
+
+	type errorPage struct {
+		Status int
+		StatusText string
+		Message string
+	}
+
+	status := errStatus(err)
+	if status >= http.StatusInternalServerError {
+		log.Printf("Responding with %d: %s", status, err)
+	}
+
+	ep := errorPage{
+		Status: status,
+		StatusText: strings.ToLower(http.StatusText(status)),
+		Message: err.Error(),
+	}
 
-// renderHTMLBodyClose renders the <body> element's closing tag in the response
-func renderHTMLBodyClose(w http.ResponseWriter) {
-	renderHTML(w, "</body>", nil)
+	p := newHTMLPage(fmt.Sprintf("soterdash - %s", ep.StatusText))
+	renderHTMLTmpl(p, "error.tmpl", ep)
+	if p.err == nil {
+		p.err = renderHTMLLayout(w, p, status)
+	}
+	if p.err != nil {
+		// The error page itself couldn't be rendered, so fall back to plain text
+		http.Error(w, err.Error(), status)
+	}
 }
 
 // renderHTMLDagForm renders the dag viewing form in the response
-func renderHTMLDagForm(w http.ResponseWriter, min, max int32) {
+func renderHTMLDagForm(p *htmlPage, min, max int32) {
 	f := dagRange{
 		Min: min,
 		Max: max,
 	}
 
-	renderHTMLTmpl(w, "dag_form.tmpl", f)
+	renderHTMLTmpl(p, "dag_form.tmpl", f)
 }
 
 // renderHTMLDagPag renders dag pagination in the response
-func renderHTMLDagPag(w http.ResponseWriter, min, max int32, amt int32) {
+func renderHTMLDagPag(p *htmlPage, min, max int32, amt int32) {
 	start := `
 <nav aria-label="dag pagination">
 	<ul class="pagination">
@@ -147,7 +327,7 @@ func renderHTMLDagPag(w http.ResponseWriter, min, max int32, amt int32) {
 	</ul>
 </nav>
 `
-	renderHTML(w, start, nil)
+	renderHTML(p, start, nil)
 
 	if min > 0 {
 		// Render previous link
@@ -167,7 +347,7 @@ func renderHTMLDagPag(w http.ResponseWriter, min, max int32, amt int32) {
 		}
 
 		tmpl := `<li class="page-item"><a class="page-link" href="/dag?min={{ .Min }}&max={{ .Max }}">Previous</a></li>`
-		renderHTML(w, tmpl, r)
+		renderHTML(p, tmpl, r)
 	}
 
 	// Render next link
@@ -180,53 +360,214 @@ func renderHTMLDagPag(w http.ResponseWriter, min, max int32, amt int32) {
 	}
 
 	tmpl := `<li class="page-item"><a class="page-link" href="/dag?min={{ .Min }}&max={{ .Max }}">Next</a></li>`
-	renderHTML(w, tmpl, r)
+	renderHTML(p, tmpl, r)
+
+	renderHTML(p, end, nil)
+}
+
+// RenderHTML renders the soterdBlock as a bootstrap card into the page
+func (b *soterdBlock) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "soterd_block.tmpl", b)
+}
+
+// RenderHTML renders the soterdNode as a bootstrap card into the page
+func (n *soterdNode) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "soterd_node.tmpl", n)
+}
+
+// RenderHTML renders the soterdCensusDiff as a bootstrap card into the page
+func (d *soterdCensusDiff) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "census_diff.tmpl", d)
+}
+
+// RenderHTML renders the soterdPeerTable as a bootstrap card into the page
+func (t *soterdPeerTable) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "rpc_peers.tmpl", t)
+}
+
+// RenderHTML renders the soterdPeer as a bootstrap card into the page
+func (peer *soterdPeer) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "rpc_peer.tmpl", peer)
+}
+
+// RenderHTML renders the soterdPropagation as a bootstrap card into the page
+func (prop *soterdPropagation) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "soterd_propagation.tmpl", prop)
+}
+
+// RenderHTML renders the soterdAlerts as a bootstrap card into the page
+func (a *soterdAlerts) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "alerts.tmpl", a)
+}
+
+// RenderHTML renders the soterdColoring as a bootstrap card into the page
+func (c *soterdColoring) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "coloring.tmpl", c)
+}
+
+// RenderHTML renders the soterdDifficulty as a bootstrap card into the page
+func (d *soterdDifficulty) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "difficulty.tmpl", d)
+}
+
+// RenderHTML renders the soterdVerification as a bootstrap card into the page
+func (v *soterdVerification) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "verify.tmpl", v)
+}
+
+// RenderHTML renders the soterdMempool as a bootstrap card into the page
+func (m *soterdMempool) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "soterd_mempool.tmpl", m)
+}
 
-	renderHTML(w, end, nil)
+// RenderHTML renders the soterdTx as a bootstrap card into the page
+func (t *soterdTx) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "soterd_tx.tmpl", t)
 }
 
-// renderHTMLHeader renders the header.tmpl template in the response
-func renderHTMLHeader(w http.ResponseWriter, title string) {
-	renderHTMLTmpl(w, "header.tmpl", title)
+// RenderHTML renders the soterdPeerGraph as a bootstrap card into the page
+func (g *soterdPeerGraph) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "peer_graph.tmpl", g)
 }
 
-// renderHTMLNavbar renders the navbar.tmpl template in the response
-func renderHTMLNavbar(w http.ResponseWriter) {
-	type navbar struct {
-		// The 'brand' name used in the navbar
-		Brand string
+// RenderHTML renders the soterdTopology as a bootstrap card into the page
+func (t *soterdTopology) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "soterd_topology.tmpl", t)
+}
+
+// RenderHTML renders the soterdRPCNode as a bootstrap card into the page
+func (rpc *soterdRPCNode) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "soterd_rpc_node.tmpl", rpc)
+}
+
+// RenderHTML renders the soterdNodeTable as a bootstrap card into the page
+func (t *soterdNodeTable) RenderHTML(p *htmlPage) {
+	renderHTMLTmpl(p, "soterd_nodes.tmpl", t)
+}
+
+// values returns the query as URL query parameters
+func (q nodeQuery) values() url.Values {
+	v := url.Values{}
+	if len(q.Text) > 0 {
+		v.Set("q", q.Text)
+	}
+	if len(q.CIDR) > 0 {
+		v.Set("cidr", q.CIDR)
+	}
+	v.Set("sort", q.Sort)
+	if q.Desc {
+		v.Set("order", "desc")
 	}
+	v.Set("page", strconv.Itoa(q.Page))
+	v.Set("per", strconv.Itoa(q.PerPage))
+	return v
+}
 
-	n := navbar{
-		Brand: "soterdash",
+// SortURL returns the node table URL for sorting by the column. Sorting by the current column reverses the order.
+func (q nodeQuery) SortURL(column string) template.URL {
+	desc := false
+	if q.Sort == column {
+		desc = !q.Desc
 	}
 
-	renderHTMLTmpl(w, "navbar.tmpl", n)
+	q.Sort = column
+	q.Desc = desc
+	q.Page = 1
+	return template.URL("/nodes?" + q.values().Encode())
+}
+
+// SortIndicator returns an arrow showing if the table is sorted by the column, and in which order
+func (q nodeQuery) SortIndicator(column string) string {
+	if q.Sort != column {
+		return ""
+	}
+	if q.Desc {
+		return "\u25bc"
+	}
+	return "\u25b2"
 }
 
-// renderHTMLFooter renders the footer.tmpl template in the response
-func renderHTMLFooter(w http.ResponseWriter) {
-	renderHTMLTmpl(w, "footer.tmpl", nil)
+// PageURL returns the node table URL for the page
+func (q nodeQuery) PageURL(page int) template.URL {
+	q.Page = page
+	return template.URL("/nodes?" + q.values().Encode())
 }
 
-// renderHTMLScript renders the script.tmpl template in the response
-func renderHTMLScript(w http.ResponseWriter) {
-	renderHTMLTmpl(w, "script.tmpl", nil)
+// DownloadURL returns the node table URL for downloading all matching rows in the format (csv or json)
+func (q nodeQuery) DownloadURL(format string) template.URL {
+	v := q.values()
+	v.Del("page")
+	v.Del("per")
+	v.Set("format", format)
+	return template.URL("/nodes?" + v.Encode())
 }
 
-// RenderHTML renders the soterdBlock as a bootstrap card in the response
-func (b *soterdBlock) RenderHTML(w http.ResponseWriter) {
-	renderHTMLTmpl(w, "soterd_block.tmpl", b)
+// values returns the query string parameters for the peer table query
+func (q peerQuery) values() url.Values {
+	v := url.Values{}
+	v.Set("sort", q.Sort)
+	if q.Desc {
+		v.Set("order", "desc")
+	}
+	return v
+}
+
+// path returns the peer table URL path for the query's RPC node
+func (q peerQuery) path() string {
+	return fmt.Sprintf("/rpcnode/%d/peers", q.RPCNode)
+}
+
+// SortURL returns the peer table URL for sorting by the column. Sorting by the current column reverses the order.
+func (q peerQuery) SortURL(column string) template.URL {
+	desc := false
+	if q.Sort == column {
+		desc = !q.Desc
+	}
+
+	q.Sort = column
+	q.Desc = desc
+	return template.URL(q.path() + "?" + q.values().Encode())
+}
+
+// SortIndicator returns an arrow showing if the table is sorted by the column, and in which order
+func (q peerQuery) SortIndicator(column string) string {
+	if q.Sort != column {
+		return ""
+	}
+	if q.Desc {
+		return "\u25bc"
+	}
+	return "\u25b2"
 }
 
-// RenderHTML renders the soterdNode as a bootstrap card in the response
-func (n *soterdNode) RenderHTML(w http.ResponseWriter) {
-	renderHTMLTmpl(w, "soterd_node.tmpl", n)
+// JSONURL returns the peer table URL for fetching the peers as JSON
+func (q peerQuery) JSONURL() string {
+	v := q.values()
+	v.Set("format", "json")
+	return q.path() + "?" + v.Encode()
 }
 
-// RenderHTML renders the soterdRPCNode as a bootstrap card in the response
-func (rpc *soterdRPCNode) RenderHTML(w http.ResponseWriter) {
-	renderHTMLTmpl(w, "soterd_rpc_node.tmpl", rpc)
+// renderCSVNodeRows renders the node table rows as a CSV file download in the response
+func renderCSVNodeRows(w http.ResponseWriter, rows []soterdNodeRow) {
+	records := [][]string{
+		{"address", "version", "status", "hops", "connections", "last_checked", "country", "city", "asn", "organization"},
+	}
+	for _, r := range rows {
+		records = append(records, []string{
+			r.Address,
+			r.Version,
+			r.Status(),
+			strconv.Itoa(r.Hops),
+			strconv.Itoa(r.Connections),
+			r.LastChecked.Format(time.RFC3339),
+			r.CountryCode,
+			r.City,
+			strconv.FormatUint(uint64(r.ASN), 10),
+			r.Organization,
+		})
+	}
+
+	renderCSV(w, "nodes.csv", records)
 }
 
 // ShortHash returns the short hash of the blocks
@@ -236,11 +577,27 @@ func (b *soterdBlock) ShortHash() string {
     return hash[smallHashIndex:]
 }
 
+// DifficultyURL returns the url of the difficulty chart for the generations around the block
+func (b *soterdBlock) DifficultyURL() string {
+	min := b.Height - difficultyDefaultRange/2
+	if min < 0 {
+		min = 0
+	}
+	return fmt.Sprintf("/difficulty?min=%d&max=%d", min, b.Height + difficultyDefaultRange/2)
+}
+
 // RenderDagsDot returns a representation of the dag in graphviz DOT file format.
 //
 // RenderDagsDot makes use of the "dot" command, which is a part of the "graphviz" suite of software.
 // http://graphviz.org/
 func RenderDagsDot(nodes []*rpcclient.Client, minHeight int32, maxHeight int32) ([]byte, error) {
+	dot, _, _, err := renderDagsDotBlocks(nodes, minHeight, maxHeight)
+	return dot, err
+}
+
+// renderDagsDotBlocks returns a representation of the dag in graphviz DOT file format, along with the blocks that were
+// rendered from the first node, by height, and a map of their hashes to their heights.
+func renderDagsDotBlocks(nodes []*rpcclient.Client, minHeight int32, maxHeight int32) ([]byte, [][]*wire.MsgBlock, map[string]int32, error) {
 	var dot bytes.Buffer
 
 	// Map blocks to the nodes that created them. This will be used to color blocks in dag
@@ -256,11 +613,12 @@ func RenderDagsDot(nodes []*rpcclient.Client, minHeight int32, maxHeight int32)
 		}
 	}
 
-	// We'll use the first node for the dag, and metrics from all nodes for block coloring
+	// We'll use the first node for the dag, and metrics from all nodes for block coloring.
+	// Fetching the coloring also records any blocks whose coloring flipped since it was last fetched.
 	node := nodes[0]
-	tips, err := node.GetDAGTips()
+	dagcoloring, tips, err := fetchColoring(node)
 	if err != nil {
-		return dot.Bytes(), err
+		return dot.Bytes(), nil, nil, err
 	}
 
 	// Determine the range of dag height we'll render
@@ -280,7 +638,7 @@ func RenderDagsDot(nodes []*rpcclient.Client, minHeight int32, maxHeight int32)
 
 		hashes, err := node.GetBlockHash(int64(height))
 		if err != nil {
-			return dot.Bytes(), err
+			return dot.Bytes(), nil, nil, err
 		}
 
 		for _, hash := range hashes {
@@ -288,7 +646,7 @@ func RenderDagsDot(nodes []*rpcclient.Client, minHeight int32, maxHeight int32)
 
 			block, err := node.GetBlock(hash)
 			if err != nil {
-				return dot.Bytes(), err
+				return dot.Bytes(), nil, nil, err
 			}
 
 			blocks = append(blocks, block)
@@ -298,10 +656,6 @@ func RenderDagsDot(nodes []*rpcclient.Client, minHeight int32, maxHeight int32)
 	}
 
 	// Build a map of block coloring results
-	dagcoloring, err := node.GetDAGColoring()
-	if err != nil {
-		return dot.Bytes(), err
-	}
 	blockcoloring := make(map[string]bool)
 	for _, dagNode := range dagcoloring {
 		hash := dagNode.Hash
@@ -319,13 +673,13 @@ func RenderDagsDot(nodes []*rpcclient.Client, minHeight int32, maxHeight int32)
 	// Specify that this graph is directed, and set the ID to 'dag'
 	_, err = fmt.Fprintln(&dot, "digraph dag {")
 	if err != nil {
-		return dot.Bytes(), err
+		return dot.Bytes(), nil, nil, err
 	}
 
 	// Set graph-level attribute to help keep a tighter left-aligned layout of graph in large renderings.
 	_, err = fmt.Fprintln(&dot, "ordering=out;")
 	if err != nil {
-		return dot.Bytes(), err
+		return dot.Bytes(), nil, nil, err
 	}
 
 	// Create a node in the graph for each block
@@ -341,19 +695,26 @@ func RenderDagsDot(nodes []*rpcclient.Client, minHeight int32, maxHeight int32)
 			dagcoloring := blockcoloring[hash]
 			creator, exists := blockCreator[hash]
 			style := stylePicker(dagcoloring, exists)
+
+			// Outline blocks whose coloring has flipped between blue and red
+			flipped := ""
+			if flips := colorTracker.flipCount(node, hash); flips > 0 {
+				flipped = fmt.Sprintf(", color=\"%s\", penwidth=3, tooltip=\"height %d hash %s flipped %d times\"", flippedColor, height, hash, flips)
+			}
+
 			var err error
 			if exists {
 				// Color this block based on which miner created it
 				color := colorPicker(creator)
-				_, err = fmt.Fprintf(&dot, "n%d [label=\"%s\", tooltip=\"node %d height %d hash %s\", href=\"%s\", fillcolor=\"%s\", style=\"%s\"];\n",
-					n, hash[smallHashIndex:], creator, height, hash, url, color, style)
+				_, err = fmt.Fprintf(&dot, "n%d [label=\"%s\", tooltip=\"node %d height %d hash %s\", href=\"%s\", fillcolor=\"%s\", style=\"%s\"%s];\n",
+					n, hash[smallHashIndex:], creator, height, hash, url, color, style, flipped)
 			} else {
 				// No color for this block
-				_, err = fmt.Fprintf(&dot, "n%d [label=\"%s\", tooltip=\"height %d hash %s\", href=\"%s\", style=\"%s\"];\n",
-					n, hash[smallHashIndex:], height, hash, url, style)
+				_, err = fmt.Fprintf(&dot, "n%d [label=\"%s\", tooltip=\"height %d hash %s\", href=\"%s\", style=\"%s\"%s];\n",
+					n, hash[smallHashIndex:], height, hash, url, style, flipped)
 			}
 			if err != nil {
-				return dot.Bytes(), err
+				return dot.Bytes(), nil, nil, err
 			}
 
 			n++
@@ -373,7 +734,7 @@ func RenderDagsDot(nodes []*rpcclient.Client, minHeight int32, maxHeight int32)
 
 				_, err := fmt.Fprintf(&dot, "n%d -> n%d;\n", blockN, parentN)
 				if err != nil {
-					return dot.Bytes(), err
+					return dot.Bytes(), nil, nil, err
 				}
 			}
 		}
@@ -382,17 +743,59 @@ func RenderDagsDot(nodes []*rpcclient.Client, minHeight int32, maxHeight int32)
 	// Close the graph statement list
 	dot.WriteString("}")
 
-	return dot.Bytes(), nil
+	return dot.Bytes(), dag, blockHeight, nil
+}
+
+// renderHTMLNodeGraphModes renders links for switching between node graph coloring modes
+func renderHTMLNodeGraphModes(p *htmlPage, mode string) {
+	type modeLink struct {
+		Mode string
+		Active bool
+	}
+
+	var links []modeLink
+	for _, m := range []string{colorModeStatus, colorModePartition, colorModeLatency} {
+		links = append(links, modeLink{Mode: m, Active: m == mode})
+	}
+
+	tmpl := `
+<ul class="nav nav-pills">
+	<li class="nav-item"><span class="nav-link disabled">color by</span></li>
+{{- range . }}
+	<li class="nav-item"><a class="nav-link{{if .Active }} active{{end}}" href="/nodegraph?color={{ .Mode }}">{{ .Mode }}</a></li>
+{{- end }}
+</ul>
+`
+	renderHTML(p, tmpl, links)
 }
 
 // RenderNodeGraphDot returns a representation of the node connectivity in graphviz DOT file format.
+// The mode determines how nodes are colored. Critical nodes (articulation points) are drawn with a thick red border,
+// and critical connections (bridges) are drawn in red.
 //
 // RenderNodeGraphDot makes use of the "dot" command, which is a part of the "graphviz" suite of software.
 // http://graphviz.org/
-func RenderNodeGraphDot() ([]byte, error) {
+func RenderNodeGraphDot(mode string) ([]byte, error) {
 	var dot bytes.Buffer
 
 	nodes := e.Nodes()
+	topo := census.Analyze(nodes)
+
+	// The slowest ping latency sets the top of the latency color scale
+	var maxPing time.Duration
+	byAddr := make(map[string]*census.Node)
+	for _, sn := range nodes {
+		byAddr[sn.Address] = sn
+		if sn.Latency.Ping > maxPing {
+			maxPing = sn.Latency.Ping
+		}
+	}
+	latencyFrac := func(d time.Duration) float64 {
+		if maxPing == 0 {
+			return 0
+		}
+		return float64(d) / float64(maxPing)
+	}
 	// graphIndex tracks node address -> graph node number, which is used to connect nodes together.
 	graphIndex := make(map[string]int)
 	// n keeps track of the 'node' number in graph file language
@@ -409,7 +812,15 @@ func RenderNodeGraphDot() ([]byte, error) {
 		graphIndex[sn.Address] = n
 
 		var color string
-		if sn.IsStale(e.Interval * 3) {
+		if mode == colorModePartition {
+			color = colorPicker(topo.Component[sn.Address])
+		} else if mode == colorModeLatency {
+			if sn.Latency.IsMeasured() {
+				color = scaleColor(latencyFrac(sn.Latency.Ping))
+			} else {
+				color = gray
+			}
+		} else if sn.IsStale(e.Interval * 3) {
 			// If we don't have new stats from the node within 3 polling intervals,
 			// we can indicate that the node's connectivity info is stale by coloring it gray.
 			color = gray
@@ -419,10 +830,15 @@ func RenderNodeGraphDot() ([]byte, error) {
 			color = orange
 		}
 
+		border := ""
+		if topo.IsArticulationPoint(sn.Address) {
+			border = fmt.Sprintf(", color=\"%s\", penwidth=3", red)
+		}
+
 		url := fmt.Sprintf("/node/%s", sn.Address)
 
-		_, err = fmt.Fprintf(&dot, "n%d [label=\"%s\", tooltip=\"version %s online %v\", href=\"%s\", fillcolor=\"%s\", style=filled];\n",
-			n, sn, sn.Version, sn.Online, url, color)
+		_, err = fmt.Fprintf(&dot, "n%d [label=\"%s\", tooltip=\"version %s online %v partition %d ping %s\", href=\"%s\", fillcolor=\"%s\", style=filled%s];\n",
+			n, sn, sn.Version, sn.Online, topo.Component[sn.Address], sn.Latency.Ping, url, color, border)
 		if err != nil {
 			return dot.Bytes(), err
 		}
@@ -430,6 +846,7 @@ func RenderNodeGraphDot() ([]byte, error) {
 	}
 
 	// Connect nodes in graph together
+	drawn := make(map[census.Edge]bool)
 	for _, soterdNode := range nodes {
 		n := graphIndex[soterdNode.Address]
 
@@ -443,7 +860,26 @@ func RenderNodeGraphDot() ([]byte, error) {
 				continue
 			}
 
-			_, err := fmt.Fprintf(&dot, "n%d -- n%d;\n", n, cn)
+			// Both ends of a connection may list each other, but we only want to draw it once
+			edge := census.NewEdge(soterdNode.Address, otherSoterdNode.Address)
+			if drawn[edge] {
+				continue
+			}
+			drawn[edge] = true
+
+			attrs := ""
+			if topo.IsBridge(edge.A, edge.B) {
+				attrs = fmt.Sprintf(" [color=\"%s\", penwidth=2]", red)
+			} else if other := byAddr[otherSoterdNode.Address]; mode == colorModeLatency && soterdNode.Latency.IsMeasured() && other.Latency.IsMeasured() {
+				// We can't measure latency between two remote nodes, so estimate it from our latency to each of them.
+				// Faster connections are drawn thicker, and pulled shorter by a higher weight.
+				est := (soterdNode.Latency.Ping + other.Latency.Ping) / 2
+				frac := latencyFrac(est)
+				attrs = fmt.Sprintf(" [color=\"%s\", penwidth=%.1f, weight=%d, tooltip=\"estimated latency %s\"]",
+					scaleColor(frac), 1 + 3 * (1 - frac), 1 + int(9 * (1 - frac)), est)
+			}
+
+			_, err := fmt.Fprintf(&dot, "n%d -- n%d%s;\n", n, cn, attrs)
 			if err != nil {
 				return dot.Bytes(), err
 			}
@@ -455,3 +891,447 @@ func RenderNodeGraphDot() ([]byte, error) {
 
 	return dot.Bytes(), nil
 }
+
+// RenderDiffDot returns a representation of the changes in node connectivity between two census snapshots, in graphviz
+// DOT file format. Nodes and connections that appeared are green, ones that disappeared are red and dashed, and nodes
+// whose version changed are orange. Everything else is gray.
+//
+// RenderDiffDot makes use of the "dot" command, which is a part of the "graphviz" suite of software.
+// http://graphviz.org/
+func RenderDiffDot(d *census.Diff) ([]byte, error) {
+	var dot bytes.Buffer
+
+	appeared := make(map[string]bool)
+	for _, n := range d.Appeared {
+		appeared[n.Address] = true
+	}
+	disappeared := make(map[string]bool)
+	for _, n := range d.Disappeared {
+		disappeared[n.Address] = true
+	}
+	changed := make(map[string]bool)
+	for _, c := range d.VersionChanged {
+		changed[c.Address] = true
+	}
+
+	// The graph includes every node and connection in either snapshot. Nodes stay in the census once they're
+	// discovered, so most nodes are in both.
+	nodes := make(map[string]bool)
+	for addr := range d.From.Nodes {
+		nodes[addr] = true
+	}
+	for addr := range d.To.Nodes {
+		nodes[addr] = true
+	}
+	addrs := make([]string, 0, len(nodes))
+	for addr := range nodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	// graphIndex tracks node address -> graph node number, which is used to connect nodes together.
+	graphIndex := make(map[string]int)
+
+	_, err := fmt.Fprintln(&dot, "graph censusDiff {")
+	if err != nil {
+		return dot.Bytes(), err
+	}
+
+	for n, addr := range addrs {
+		graphIndex[addr] = n
+
+		color, style, change := gray, "filled", "unchanged"
+		if appeared[addr] {
+			color, change = green, "appeared"
+		} else if disappeared[addr] {
+			color, style, change = red, "filled, dashed", "disappeared"
+		} else if changed[addr] {
+			color, change = orange, "version changed"
+		}
+
+		url := fmt.Sprintf("/node/%s", addr)
+		_, err = fmt.Fprintf(&dot, "n%d [label=\"%s\", tooltip=\"%s\", href=\"%s\", fillcolor=\"%s\", style=\"%s\"];\n",
+			n, addr, change, url, color, style)
+		if err != nil {
+			return dot.Bytes(), err
+		}
+	}
+
+	added := make(map[census.Edge]bool)
+	for _, edge := range d.EdgesAdded {
+		added[edge] = true
+	}
+
+	var edges []census.Edge
+	for edge := range d.To.Edges {
+		edges = append(edges, edge)
+	}
+	edges = append(edges, d.EdgesRemoved...)
+
+	for _, edge := range edges {
+		// Skip connections to nodes that aren't in the graph, rather than drawing them to another node
+		a, aOk := graphIndex[edge.A]
+		b, bOk := graphIndex[edge.B]
+		if !aOk || !bOk {
+			continue
+		}
+
+		attrs := fmt.Sprintf("color=\"%s\"", gray)
+		if added[edge] {
+			attrs = fmt.Sprintf("color=\"%s\", penwidth=2", green)
+		} else if !d.To.Edges[edge] {
+			attrs = fmt.Sprintf("color=\"%s\", penwidth=2, style=dashed", red)
+		}
+
+		_, err := fmt.Fprintf(&dot, "n%d -- n%d [%s];\n", a, b, attrs)
+		if err != nil {
+			return dot.Bytes(), err
+		}
+	}
+
+	// Close the graph statement list
+	dot.WriteString("}")
+
+	return dot.Bytes(), nil
+}
+
+// RenderPeerGraphDot returns a representation of the RPC nodes' peer connections in graphviz DOT file format.
+// RPC nodes are drawn as green boxes, and connections point from the node that dialed to the node that accepted.
+// Connections that the census doesn't see are orange, and connections that only the census sees are red and dashed.
+//
+// RenderPeerGraphDot makes use of the "dot" command, which is a part of the "graphviz" suite of software.
+// http://graphviz.org/
+func RenderPeerGraphDot(g *soterdPeerGraph) ([]byte, error) {
+	var dot bytes.Buffer
+
+	isRPCNode := make(map[string]bool)
+	for _, addr := range g.RPCNodes {
+		isRPCNode[addr] = true
+	}
+	peerOnly := make(map[census.Edge]bool)
+	for _, edge := range g.PeerOnly {
+		peerOnly[edge] = true
+	}
+
+	// Collect every node that's an end of a connection
+	var addrs []string
+	found := make(map[string]bool)
+	addAddr := func(addr string) {
+		if !found[addr] {
+			found[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, addr := range g.RPCNodes {
+		addAddr(addr)
+	}
+	for _, pe := range g.Edges {
+		addAddr(pe.To)
+	}
+	for _, edge := range g.CensusOnly {
+		addAddr(edge.A)
+		addAddr(edge.B)
+	}
+
+	// graphIndex tracks node address -> graph node number, which is used to connect nodes together.
+	graphIndex := make(map[string]int)
+
+	_, err := fmt.Fprintln(&dot, "digraph peerGraph {")
+	if err != nil {
+		return dot.Bytes(), err
+	}
+
+	for n, addr := range addrs {
+		graphIndex[addr] = n
+
+		attrs := fmt.Sprintf("fillcolor=\"%s\", style=filled", gray)
+		if isRPCNode[addr] {
+			attrs = fmt.Sprintf("fillcolor=\"%s\", style=filled, shape=box", green)
+		}
+		if _, exists := e.Get(addr); exists {
+			attrs += fmt.Sprintf(", href=\"/node/%s\"", addr)
+		}
+
+		_, err = fmt.Fprintf(&dot, "n%d [label=\"%s\", %s];\n", n, addr, attrs)
+		if err != nil {
+			return dot.Bytes(), err
+		}
+	}
+
+	for _, pe := range g.Edges {
+		from, to := graphIndex[pe.From], graphIndex[pe.To]
+		if pe.Inbound {
+			from, to = to, from
+		}
+
+		color := gray
+		if peerOnly[census.NewEdge(pe.From, pe.To)] {
+			color = orange
+		}
+
+		_, err = fmt.Fprintf(&dot, "n%d -> n%d [color=\"%s\", label=\"%s\", tooltip=\"ping %s, sent %d bytes, received %d bytes, connected for %s\"];\n",
+			from, to, color, pe.PingTime, pe.PingTime, pe.BytesSent, pe.BytesRecv, pe.Duration)
+		if err != nil {
+			return dot.Bytes(), err
+		}
+	}
+
+	for _, edge := range g.CensusOnly {
+		_, err = fmt.Fprintf(&dot, "n%d -> n%d [color=\"%s\", style=dashed, dir=none, tooltip=\"only seen by the census\"];\n",
+			graphIndex[edge.A], graphIndex[edge.B], red)
+		if err != nil {
+			return dot.Bytes(), err
+		}
+	}
+
+	// Close the graph statement list
+	dot.WriteString("}")
+
+	return dot.Bytes(), nil
+}
+
+// byteRateFormatter formats a chart value in bytes per second
+func byteRateFormatter(v interface{}) string {
+	rate, ok := v.(float64)
+	if !ok {
+		return ""
+	}
+
+	switch {
+	case rate >= 1 << 20:
+		return fmt.Sprintf("%.1f MB/s", rate / (1 << 20))
+	case rate >= 1 << 10:
+		return fmt.Sprintf("%.1f KB/s", rate / (1 << 10))
+	default:
+		return fmt.Sprintf("%.0f B/s", rate)
+	}
+}
+
+// renderChartSvg renders the time series as an SVG line chart of bytes per second
+func renderChartSvg(title string, series []chart.Series) ([]byte, error) {
+	graph := chart.Chart{
+		Title: title,
+		TitleStyle: chart.StyleShow(),
+		Width: 800,
+		Height: 300,
+		XAxis: chart.XAxis{
+			Style: chart.StyleShow(),
+			ValueFormatter: chart.TimeMinuteValueFormatter,
+		},
+		YAxis: chart.YAxis{
+			Style: chart.StyleShow(),
+			ValueFormatter: byteRateFormatter,
+		},
+		Series: series,
+	}
+	graph.Elements = []chart.Renderable{chart.Legend(&graph)}
+
+	var svg bytes.Buffer
+	err := graph.Render(chart.SVG, &svg)
+	if err != nil {
+		return svg.Bytes(), err
+	}
+
+	return svg.Bytes(), nil
+}
+
+// RenderBandwidthSvg returns an SVG chart of the total, inbound and outbound traffic rates
+func RenderBandwidthSvg(rates []bandwidthRate) ([]byte, error) {
+	var times []time.Time
+	var total, recv, sent []float64
+	for _, r := range rates {
+		times = append(times, r.Time)
+		total = append(total, r.Sent + r.Recv)
+		recv = append(recv, r.Recv)
+		sent = append(sent, r.Sent)
+	}
+
+	return renderChartSvg("Bandwidth", []chart.Series{
+		chart.TimeSeries{Name: "total", XValues: times, YValues: total},
+		chart.TimeSeries{Name: "inbound", XValues: times, YValues: recv},
+		chart.TimeSeries{Name: "outbound", XValues: times, YValues: sent},
+	})
+}
+
+// RenderTalkersSvg returns an SVG chart of the traffic rates of the top talkers
+func RenderTalkersSvg(rates []bandwidthRate, talkers []talker) ([]byte, error) {
+	var times []time.Time
+	for _, r := range rates {
+		times = append(times, r.Time)
+	}
+
+	var series []chart.Series
+	for _, t := range talkers {
+		var values []float64
+		for _, r := range rates {
+			values = append(values, r.Peers[t.Addr])
+		}
+		series = append(series, chart.TimeSeries{Name: t.Addr, XValues: times, YValues: values})
+	}
+
+	return renderChartSvg("Top talkers", series)
+}
+
+// RenderDelaySvg returns an SVG bar chart of how many propagation delays fall in each bucket
+func RenderDelaySvg(buckets []delayBucket) ([]byte, error) {
+	var bars []chart.Value
+	for _, b := range buckets {
+		bars = append(bars, chart.Value{Label: b.Label, Value: float64(b.Count)})
+	}
+
+	graph := chart.BarChart{
+		Title: "Propagation delay",
+		TitleStyle: chart.StyleShow(),
+		Width: 800,
+		Height: 300,
+		BarWidth: 60,
+		XAxis: chart.StyleShow(),
+		YAxis: chart.YAxis{
+			Style: chart.StyleShow(),
+			ValueFormatter: func(v interface{}) string {
+				return fmt.Sprintf("%.0f", v)
+			},
+		},
+		Bars: bars,
+	}
+
+	var svg bytes.Buffer
+	err := graph.Render(chart.SVG, &svg)
+	if err != nil {
+		return svg.Bytes(), err
+	}
+
+	return svg.Bytes(), nil
+}
+
+// paddedRange returns a chart range from zero that fits the values, with some room above the largest one
+func paddedRange(values []float64) *chart.ContinuousRange {
+	max := 0.0
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	if max <= 0 {
+		max = 1
+	}
+
+	return &chart.ContinuousRange{Min: 0, Max: max * 1.1}
+}
+
+// RenderDifficultySvg returns an SVG chart of the difficulty of each generation and its blocks, against the interval
+// between generations, with the retarget points marked
+func RenderDifficultySvg(generations []generationDifficulty) ([]byte, error) {
+	var heights, difficulties []float64
+	var intervalHeights, intervals []float64
+	var blockHeights, blockDifficulties []float64
+	var retargets []chart.Value2
+	for i, g := range generations {
+		heights = append(heights, float64(g.Height))
+		difficulties = append(difficulties, g.Difficulty)
+
+		// The interval of the genesis generation isn't known
+		if i > 0 || g.Height > 0 {
+			intervalHeights = append(intervalHeights, float64(g.Height))
+			intervals = append(intervals, g.Interval.Seconds())
+		}
+
+		for _, b := range g.Blocks {
+			blockHeights = append(blockHeights, float64(b.Height))
+			blockDifficulties = append(blockDifficulties, b.Difficulty)
+		}
+
+		if g.Retarget {
+			retargets = append(retargets, chart.Value2{
+				XValue: float64(g.Height),
+				YValue: g.Difficulty,
+				Label: fmt.Sprintf("retarget %d", g.Height),
+			})
+		}
+	}
+
+	series := []chart.Series{
+		chart.ContinuousSeries{
+			Name: "block difficulty",
+			Style: chart.Style{
+				Show: true,
+				StrokeWidth: chart.Disabled,
+				DotWidth: 3,
+				DotColor: chart.GetDefaultColor(0).WithAlpha(128),
+			},
+			XValues: blockHeights,
+			YValues: blockDifficulties,
+		},
+		chart.ContinuousSeries{
+			Name: "median difficulty",
+			Style: chart.Style{
+				Show: true,
+				StrokeColor: chart.GetDefaultColor(0),
+			},
+			XValues: heights,
+			YValues: difficulties,
+		},
+		chart.ContinuousSeries{
+			Name: "interval",
+			Style: chart.Style{
+				Show: true,
+				StrokeColor: chart.GetDefaultColor(1),
+			},
+			YAxis: chart.YAxisSecondary,
+			XValues: intervalHeights,
+			YValues: intervals,
+		},
+	}
+	if len(retargets) > 0 {
+		series = append(series, chart.AnnotationSeries{
+			Name: "retargets",
+			Annotations: retargets,
+		})
+	}
+
+	graph := chart.Chart{
+		Width: 800,
+		Height: 300,
+		// Leave room above the plot for the legend
+		Background: chart.Style{
+			Padding: chart.Box{Top: 40, Left: 20, Right: 20, Bottom: 20},
+		},
+		XAxis: chart.XAxis{
+			Name: "height",
+			NameStyle: chart.StyleShow(),
+			Style: chart.StyleShow(),
+			ValueFormatter: func(v interface{}) string {
+				return fmt.Sprintf("%.0f", v)
+			},
+		},
+		YAxis: chart.YAxis{
+			Name: "difficulty",
+			NameStyle: chart.StyleShow(),
+			Style: chart.StyleShow(),
+			Range: paddedRange(blockDifficulties),
+			ValueFormatter: func(v interface{}) string {
+				return fmt.Sprintf("%.4g", v)
+			},
+		},
+		YAxisSecondary: chart.YAxis{
+			Name: "interval",
+			NameStyle: chart.StyleShow(),
+			Style: chart.StyleShow(),
+			Range: paddedRange(intervals),
+			ValueFormatter: func(v interface{}) string {
+				if f, ok := v.(float64); ok {
+					return (time.Duration(f) * time.Second).String()
+				}
+				return ""
+			},
+		},
+		Series: series,
+	}
+	graph.Elements = []chart.Renderable{chart.LegendThin(&graph)}
+
+	var svg bytes.Buffer
+	err := graph.Render(chart.SVG, &svg)
+	if err != nil {
+		return svg.Bytes(), err
+	}
+
+	return svg.Bytes(), nil
+}
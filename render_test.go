@@ -0,0 +1,98 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soteria-dag/soterdash/census"
+)
+
+// snapshot returns a census snapshot of the nodes, which are online unless they're in offline, and the edges
+func snapshot(t time.Time, addrs []string, offline []string, edges ...census.Edge) *census.Snapshot {
+	s := census.Snapshot{
+		Time: t,
+		Nodes: make(map[string]census.NodeState),
+		Edges: make(map[census.Edge]bool),
+	}
+
+	for _, addr := range addrs {
+		s.Nodes[addr] = census.NodeState{Address: addr, Version: "1.0.0", Online: true}
+	}
+	for _, addr := range offline {
+		s.Nodes[addr] = census.NodeState{Address: addr, Version: "1.0.0"}
+	}
+	for _, e := range edges {
+		s.Edges[e] = true
+	}
+
+	return &s
+}
+
+// countLabel returns how many graph nodes in the DOT file have the label
+func countLabel(dot []byte, label string) int {
+	return strings.Count(string(dot), fmt.Sprintf("[label=\"%s\",", label))
+}
+
+// TestRenderDiffDotOffline checks that a node that goes offline is drawn once, as disappeared, and that its connection
+// is drawn as removed between the right nodes.
+func TestRenderDiffDotOffline(t *testing.T) {
+	now := time.Now()
+	a, b, c := "10.0.0.1:18555", "10.0.0.2:18555", "10.0.0.3:18555"
+
+	from := snapshot(now.Add(-time.Hour), []string{a, b, c}, nil, census.NewEdge(a, b), census.NewEdge(b, c))
+	to := snapshot(now, []string{a, b}, []string{c}, census.NewEdge(a, b))
+
+	d := census.Compare(from, to)
+	if len(d.Disappeared) != 1 || d.Disappeared[0].Address != c {
+		t.Fatalf("expected %s to disappear, got %v", c, d.Disappeared)
+	}
+	if len(d.Appeared) != 0 {
+		t.Fatalf("expected no nodes to appear, got %v", d.Appeared)
+	}
+	if len(d.EdgesRemoved) != 1 || d.EdgesRemoved[0] != census.NewEdge(b, c) {
+		t.Fatalf("expected the %s -- %s connection to be removed, got %v", b, c, d.EdgesRemoved)
+	}
+
+	dot, err := RenderDiffDot(d)
+	if err != nil {
+		t.Fatalf("RenderDiffDot: %s", err)
+	}
+
+	for _, addr := range []string{a, b, c} {
+		if n := countLabel(dot, addr); n != 1 {
+			t.Errorf("expected %s to be drawn once, it was drawn %d times:\n%s", addr, n, dot)
+		}
+	}
+
+	// Nodes are numbered by address, so the removed connection is between the second and third nodes
+	if !strings.Contains(string(dot), fmt.Sprintf("n1 -- n2 [color=\"%s\", penwidth=2, style=dashed];", red)) {
+		t.Errorf("expected the removed connection to be drawn between n1 and n2:\n%s", dot)
+	}
+}
+
+// TestRenderDiffDotMissingNode checks that a connection to a node that isn't in either snapshot isn't drawn
+func TestRenderDiffDotMissingNode(t *testing.T) {
+	now := time.Now()
+	a, b, missing := "10.0.0.1:18555", "10.0.0.2:18555", "10.0.0.9:18555"
+
+	d := &census.Diff{
+		From: snapshot(now.Add(-time.Hour), []string{a, b}, nil),
+		To: snapshot(now, []string{a, b}, nil),
+		EdgesRemoved: []census.Edge{census.NewEdge(b, missing)},
+	}
+
+	dot, err := RenderDiffDot(d)
+	if err != nil {
+		t.Fatalf("RenderDiffDot: %s", err)
+	}
+
+	if strings.Contains(string(dot), " -- ") {
+		t.Errorf("expected no connections to be drawn:\n%s", dot)
+	}
+}
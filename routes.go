@@ -5,34 +5,69 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/soteria-dag/soterdash/census"
 	"github.com/soteria-dag/soterd/soterutil"
 )
 
-// beforeBody renders common HTML document sections including the opening <body> element
-func beforeBody(w http.ResponseWriter, title string) {
-	renderHTMLOpen(w)
-	renderHTMLHeader(w, title)
-	renderHTMLBodyOpen(w)
-	renderHTMLNavbar(w)
+const (
+	// How many census events can be queued for an /events client before events are dropped
+	eventBuffer = 100
+	// How often an idle /events stream sends a keepalive comment
+	eventKeepalive = time.Second * 15
+	// The largest census report accepted from an agent
+	maxReportSize = 32 << 20
+)
+
+// parseFormInt returns the form value with the given key as an int
+func parseFormInt(r *http.Request, key string) (int, error) {
+	v := r.FormValue(key)
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value '%s': %s", key, v, err)
+	}
+
+	return i, nil
 }
 
-// afterBody renders common HTML document sections starting from the closing </body> element
-// to the end of the HTML document.
-func afterBody(w http.ResponseWriter) {
-	renderHTMLFooter(w)
-	renderHTMLScript(w)
-	renderHTMLBodyClose(w)
-	renderHTMLClose(w)
+// parseTimeParam parses a time query parameter. It accepts RFC3339 times, "2006-01-02 15:04" and "15:04" local times
+// (the latter meaning today), and durations like "30m" meaning that long ago.
+func parseTimeParam(v string) (time.Time, error) {
+	now := time.Now()
+
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", v, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("15:04", v, time.Local); err == nil {
+		y, m, d := now.Date()
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, time.Local), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time '%s': use RFC3339, \"2006-01-02 15:04\", \"15:04\" or a duration like 30m", v)
 }
 
 // handleRoot responds to requests for root url /
 func handleRoot(w http.ResponseWriter, r *http.Request) {
+	// The / pattern matches any path that no other pattern does
+	if r.URL.Path != "/" {
+		renderHTMLErr(w, newHTTPError(http.StatusNotFound, "page %s not found", r.URL.Path))
+		return
+	}
+
 	// By default we'll print out node information
 	handleRPCNodes(w, r)
 }
@@ -56,29 +91,266 @@ func handleBlock(w http.ResponseWriter, r *http.Request) {
 
 	// TODO(cedric): If there's no block specified, let the user search for one using a hash (render a search bar)
 	if len(parts) != 3 {
-		renderHTMLErr(w, fmt.Errorf("couldn't find block hash in request url: %s", r.URL.Path))
+		renderHTMLErr(w, newHTTPError(http.StatusNotFound, "couldn't find block hash in request url: %s", r.URL.Path))
 		return
 	}
 	block := parts[2]
 
 	client, err := pickClient(clients)
 	if err != nil {
-		renderHTMLErr(w, fmt.Errorf("couldn't pick a soterd node to use: %s", err))
+		renderHTMLErr(w, newHTTPError(http.StatusServiceUnavailable, "couldn't pick a soterd node to use: %s", err))
 		return
 	}
 
-	// Render the different HTML sections for the response
-	beforeBody(w, title)
+	info, err := blockInfo(client, block)
+	if err != nil {
+		renderHTMLErr(w, err)
+		return
+	}
 
 	// Render block info
-	info, err := blockInfo(client, block)
+	p := newHTMLPage(title)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handlePropagation responds to requests for /propagation
+// It renders block propagation timing across the RPC nodes
+func handlePropagation(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - block propagation"
+
+	info, err := propagationInfo()
+	if err != nil {
+		renderHTMLErr(w, err)
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleDifficulty responds to requests for /difficulty
+// It renders the difficulty of each generation and block between the min and max heights, against the interval
+// between generations, along with the retarget points and the target expected next.
+func handleDifficulty(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - difficulty"
+
+	client, err := pickClient(clients)
+	if err != nil {
+		renderHTMLErr(w, newHTTPError(http.StatusServiceUnavailable, "couldn't pick a soterd node to use: %s", err))
+		return
+	}
+
+	tips, err := client.GetDAGTips()
+	if err != nil {
+		renderHTMLErr(w, rpcError(err))
+		return
+	}
+
+	values := r.URL.Query()
+	minHeight := tips.MaxHeight - difficultyDefaultRange
+	maxHeight := tips.MaxHeight
+	if v := values.Get("min"); len(v) > 0 {
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid min height '%s'", v))
+			return
+		}
+		minHeight = int32(i)
+	}
+	if v := values.Get("max"); len(v) > 0 {
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid max height '%s'", v))
+			return
+		}
+		maxHeight = int32(i)
+	}
+
+	if minHeight < 0 {
+		minHeight = 0
+	}
+	if maxHeight > tips.MaxHeight {
+		maxHeight = tips.MaxHeight
+	}
+	if maxHeight-minHeight >= difficultyFetchLimit {
+		renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "can't sample more than %d generations", difficultyFetchLimit))
+		return
+	}
+
+	info, err := difficultyInfo(client, minHeight, maxHeight, tips.MaxHeight)
+	if err != nil {
+		renderHTMLErr(w, err)
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	renderHTMLDagForm(p, minHeight, maxHeight)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleAlerts responds to requests for /alerts
+// It renders the alert rules, the active alerts and recently resolved alerts.
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - alerts"
+
+	info, err := alertsInfo()
+	if err != nil {
+		renderHTMLErr(w, err)
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleColoring responds to requests for /coloring
+// It renders the blocks that flipped between blue and red, for the RPC node chosen with the node query parameter
+// or for every RPC node.
+func handleColoring(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - coloring changes"
+
+	id := -1
+	if v := r.URL.Query().Get("node"); len(v) > 0 {
+		var err error
+		id, err = strconv.Atoi(v)
+		if err != nil || id < 0 {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid rpc node id '%s'", v))
+			return
+		}
+	}
+
+	info, err := coloringInfo(id)
+	if err != nil {
+		renderHTMLErr(w, err)
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleVerify responds to requests for /verify
+// It compares each RPC node's dag coloring between the min and max heights with an independently computed blue set.
+func handleVerify(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - coloring verification"
+
+	client, err := pickClient(clients)
+	if err != nil {
+		renderHTMLErr(w, newHTTPError(http.StatusServiceUnavailable, "couldn't pick a soterd node to use: %s", err))
+		return
+	}
+
+	tips, err := client.GetDAGTips()
+	if err != nil {
+		renderHTMLErr(w, rpcError(err))
+		return
+	}
+
+	values := r.URL.Query()
+	minHeight := tips.MaxHeight - verifyDefaultRange
+	maxHeight := tips.MaxHeight
+	if v := values.Get("min"); len(v) > 0 {
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid min height '%s'", v))
+			return
+		}
+		minHeight = int32(i)
+	}
+	if v := values.Get("max"); len(v) > 0 {
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid max height '%s'", v))
+			return
+		}
+		maxHeight = int32(i)
+	}
+
+	if minHeight < 0 {
+		minHeight = 0
+	}
+	if maxHeight > tips.MaxHeight {
+		maxHeight = tips.MaxHeight
+	}
+	if tips.MaxHeight-(minHeight-verifyAnchorDepth) >= verifyFetchLimit {
+		renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "can only verify the latest %d generations of the dag",
+			verifyFetchLimit-verifyAnchorDepth))
+		return
+	}
+
+	info, err := verificationInfo(minHeight, maxHeight)
 	if err != nil {
 		renderHTMLErr(w, err)
+		return
 	}
-	info.RenderHTML(w)
 
-	// Render HTML sections after the body
-	afterBody(w)
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	renderHTMLDagForm(p, minHeight, maxHeight)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleMempool responds to requests for /mempool
+// It renders an RPC node's mempool, chosen with the node query parameter, and compares the mempools of all RPC nodes.
+func handleMempool(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - mempool"
+
+	id := 0
+	if v := r.URL.Query().Get("node"); len(v) > 0 {
+		var err error
+		id, err = strconv.Atoi(v)
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid rpc node id '%s'", v))
+			return
+		}
+	}
+
+	info, err := mempoolInfo(id)
+	if err != nil {
+		renderHTMLErr(w, rpcError(err))
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleTx responds to requests for /tx
+// It renders transaction details
+func handleTx(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - transaction"
+
+	// For r.URL.Path of /tx/09d41fa, parts will be: ["", "tx", "09d41fa"]
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 3 {
+		renderHTMLErr(w, newHTTPError(http.StatusNotFound, "couldn't find transaction hash in request url: %s", r.URL.Path))
+		return
+	}
+
+	info, err := txInfo(parts[2])
+	if err != nil {
+		renderHTMLErr(w, err)
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
 }
 
 // handleDag responds to requests for /dag, which renders the dag with the given parameters
@@ -87,11 +359,12 @@ func handleDag(w http.ResponseWriter, r *http.Request) {
 	// How many blocks we'll paginate per 'page'
 	pagAmt := int32(10)
 
-	client, err := pickClient(clients)
-	if err != nil {
-		renderHTMLErr(w, fmt.Errorf("couldn't pick a soterd node to use: %s", err))
+	// The dag is rendered from the first node, so its tips bound the range
+	if len(clients) == 0 {
+		renderHTMLErr(w, newHTTPError(http.StatusServiceUnavailable, "couldn't pick a soterd node to use: clients slice is empty"))
 		return
 	}
+	client := clients[0]
 
 	// Parse query parameters from request URL
 	values := r.URL.Query()
@@ -101,7 +374,8 @@ func handleDag(w http.ResponseWriter, r *http.Request) {
 
 	tips, err := client.GetDAGTips()
 	if err != nil {
-		renderHTMLErr(w, err)
+		renderHTMLErr(w, rpcError(err))
+		return
 	}
 
 	if len(min) == 0 {
@@ -109,7 +383,8 @@ func handleDag(w http.ResponseWriter, r *http.Request) {
 	} else {
 		i, err := strconv.ParseInt(min, 10, 32)
 		if err != nil {
-			renderHTMLErr(w, err)
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid min height '%s'", min))
+			return
 		}
 		minHeight = int32(i)
 	}
@@ -123,43 +398,55 @@ func handleDag(w http.ResponseWriter, r *http.Request) {
 	} else {
 		i, err := strconv.ParseInt(max, 10, 32)
 		if err != nil {
-			renderHTMLErr(w, err)
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid max height '%s'", max))
+			return
 		}
 		maxHeight = int32(i)
 	}
 
-	// Render the different HTML sections for the response
-	beforeBody(w, title)
-	renderHTML(w, "<br>", nil)
-
-	// Render form for updating dag view
-	formMaxHeight := maxHeight
-	if formMaxHeight > tips.MaxHeight {
-		formMaxHeight = tips.MaxHeight
+	if maxHeight > tips.MaxHeight {
+		maxHeight = tips.MaxHeight
+	}
+	if maxHeight-minHeight >= dagFetchLimit {
+		renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "can't render more than %d generations", dagFetchLimit))
+		return
 	}
-	renderHTMLDagForm(w, minHeight, formMaxHeight)
-	renderHTML(w, "<br>", nil)
 
 	// Dag svg rendering
-	dot, err := RenderDagsDot(clients, minHeight, maxHeight)
+	dot, dag, heights, err := renderDagsDotBlocks(clients, minHeight, maxHeight)
 	if err != nil {
-		renderHTMLErr(w, err)
+		renderHTMLErr(w, rpcError(err))
+		return
 	}
 	svg, err := soterutil.DotToSvg(dot)
 	if err != nil {
 		renderHTMLErr(w, err)
+		return
 	}
 	svgEmbed, err := soterutil.StripSvgXmlDecl(svg)
 	if err != nil {
 		renderHTMLErr(w, err)
+		return
 	}
-	renderHTML(w, "<figure>{{ . }}</figure>", template.HTML(svgEmbed))
+
+	// Check the integrity of the rendered blocks
+	integrity := newIntegrityChecker(client, activeNet).checkBlocks(dag, heights)
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+
+	// Render form for updating dag view
+	renderHTMLDagForm(p, minHeight, maxHeight)
+	renderHTML(p, "<br>", nil)
+
+	renderHTML(p, "<figure>{{ . }}</figure>", template.HTML(svgEmbed))
 
 	// Render dag pagination links
-	renderHTMLDagPag(w, minHeight, formMaxHeight, pagAmt)
+	renderHTMLDagPag(p, minHeight, maxHeight, pagAmt)
+	renderHTML(p, "<br>", nil)
+	renderHTMLTmpl(p, "dag_integrity.tmpl", integrity)
 
-	// Render HTML sections after the body
-	afterBody(w)
+	writeHTMLPage(w, p)
 }
 
 // handleNode responds to requests for /node
@@ -172,25 +459,144 @@ func handleNode(w http.ResponseWriter, r *http.Request) {
 
 	// TODO(cedric): If there's no node specified, let the user search for one using an address (render a search bar)?
 	if len(parts) != 3 {
-		renderHTMLErr(w, fmt.Errorf("couldn't find node address in request url: %s", r.URL.Path))
+		renderHTMLErr(w, newHTTPError(http.StatusNotFound, "couldn't find node address in request url: %s", r.URL.Path))
 		return
 	}
 
 	address := parts[2]
 
-	// Render the different HTML sections for the response
-	beforeBody(w, title)
-	renderHTML(w, "<br>", nil)
-
 	nodeInfo, err := nodeInfo(address)
 	if err != nil {
 		renderHTMLErr(w, err)
 		return
 	}
-	nodeInfo.RenderHTML(w)
 
-	// Render HTML sections after the body
-	afterBody(w)
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	nodeInfo.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleNodes responds to requests for /nodes
+// It renders a table of census-enumerated nodes, or downloads it as CSV or JSON when a format is given.
+func handleNodes(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - nodes"
+
+	// Parse query parameters from request URL
+	values := r.URL.Query()
+	q := nodeQuery{
+		Text: strings.TrimSpace(values.Get("q")),
+		CIDR: strings.TrimSpace(values.Get("cidr")),
+		Sort: values.Get("sort"),
+		Desc: values.Get("order") == "desc",
+		Page: 1,
+		PerPage: 50,
+	}
+	if len(q.Sort) == 0 {
+		q.Sort = "address"
+	}
+	if p, err := strconv.Atoi(values.Get("page")); err == nil && p > 0 {
+		q.Page = p
+	}
+	if p, err := strconv.Atoi(values.Get("per")); err == nil && p > 0 {
+		q.PerPage = p
+	}
+
+	switch format := values.Get("format"); format {
+	case "":
+	case "csv", "json":
+		rows, err := nodeRows(q)
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "%s", err))
+			return
+		}
+
+		if format == "csv" {
+			renderCSVNodeRows(w, rows)
+		} else {
+			renderJSON(w, rows)
+		}
+		return
+	default:
+		renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "unknown format '%s'", format))
+		return
+	}
+
+	table, err := nodeTable(q)
+	if err != nil {
+		renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "%s", err))
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	table.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleEvents responds to requests for /events
+// It streams census events as server-sent events, optionally limited to a comma-separated list of event types.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var types []census.EventType
+	for _, t := range strings.Split(r.URL.Query().Get("type"), ",") {
+		if len(t) == 0 {
+			continue
+		}
+
+		et, err := census.ParseEventType(t)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		types = append(types, et)
+	}
+
+	sub := e.Subscribe(eventBuffer, types...)
+	defer sub.Close()
+
+	setContentType(w, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Periodically send a comment, so that idle connections aren't closed by proxies
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+			case ev, ok := <-sub.C:
+				if !ok {
+					// The census was stopped
+					return
+				}
+
+				data, err := json.Marshal(ev)
+				if err != nil {
+					return
+				}
+
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-keepalive.C:
+				_, err := fmt.Fprint(w, ": keepalive\n\n")
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+		}
+	}
 }
 
 // handleNodeGraph responds to requests for /nodegraph
@@ -198,27 +604,99 @@ func handleNode(w http.ResponseWriter, r *http.Request) {
 func handleNodeGraph(w http.ResponseWriter, r *http.Request) {
 	title := "soterdash - node graph"
 
-	// Render the different HTML sections for the response
-	beforeBody(w, title)
-	renderHTML(w, "<br>", nil)
+	mode := r.URL.Query().Get("color")
+	if len(mode) == 0 {
+		mode = colorModeStatus
+	}
+
+	switch mode {
+	case colorModeStatus, colorModePartition, colorModeLatency:
+	default:
+		renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "unknown color mode '%s'", mode))
+		return
+	}
 
 	// Render node graph
-	dot, err := RenderNodeGraphDot()
+	dot, err := RenderNodeGraphDot(mode)
 	if err != nil {
 		renderHTMLErr(w, err)
+		return
 	}
 	svg, err := soterutil.DotToSvg(dot)
 	if err != nil {
 		renderHTMLErr(w, err)
+		return
 	}
 	svgEmbed, err := soterutil.StripSvgXmlDecl(svg)
 	if err != nil {
 		renderHTMLErr(w, err)
+		return
 	}
-	renderHTML(w, "<figure>{{ . }}</figure>", template.HTML(svgEmbed))
 
-	// Render HTML sections after the body
-	afterBody(w)
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	renderHTMLNodeGraphModes(p, mode)
+	renderHTML(p, "<figure>{{ . }}</figure>", template.HTML(svgEmbed))
+	writeHTMLPage(w, p)
+}
+
+// handleCensusDiff responds to requests for /census/diff
+// It renders the changes in the census between the from and to times. The to time defaults to now.
+func handleCensusDiff(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - census diff"
+
+	// Parse query parameters from request URL
+	values := r.URL.Query()
+	fromParam := values.Get("from")
+	toParam := values.Get("to")
+	fromDefault := len(fromParam) == 0
+	if fromDefault {
+		fromParam = "30m"
+	}
+
+	from, err := parseTimeParam(fromParam)
+	if err != nil {
+		renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "%s", err))
+		return
+	}
+
+	var to time.Time
+	if len(toParam) > 0 {
+		to, err = parseTimeParam(toParam)
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "%s", err))
+			return
+		}
+		if to.Before(from) {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "to time %s is before from time %s",
+				to.Format(time.RFC3339), from.Format(time.RFC3339)))
+			return
+		}
+	}
+
+	info, err := censusDiffInfo(fromParam, toParam, from, to, fromDefault)
+	if err != nil {
+		renderHTMLErr(w, err)
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleTopology responds to requests for /topology
+// It renders an analysis of the census-enumerated node graph
+func handleTopology(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - topology"
+
+	info := topologyInfo()
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
 }
 
 // handleRPCNodes responds to requests for /rpcnodes
@@ -226,21 +704,258 @@ func handleNodeGraph(w http.ResponseWriter, r *http.Request) {
 func handleRPCNodes(w http.ResponseWriter, r *http.Request) {
 	title := "soterdash - rpcnodes"
 
-	// Render the different HTML sections for the response
-	beforeBody(w, title)
-	renderHTML(w, "<br>", nil)
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+
+	// A node that can't be reached is shown as an error, so that the other nodes are still shown
+	renderNodeErr := func(id int, err error) {
+		renderHTMLTmpl(p, "rpc_node_error.tmpl", struct {
+			Id int
+			Err error
+		}{id, err})
+	}
 
+	var firstErr error
+	failed := 0
 	for id, client := range clients {
 		// Render node info
 		info, err := rpcNodeInfo(client)
 		if err != nil {
-			renderHTMLErr(w, err)
+			err = rpcError(err)
+		} else {
+			info.Id = id
+			info.Bandwidth, err = bandwidthInfo(id)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			renderNodeErr(id, err)
+			continue
+		}
+
+		info.RenderHTML(p)
+	}
+
+	if len(clients) > 0 && failed == len(clients) {
+		renderHTMLErr(w, firstErr)
+		return
+	}
+
+	writeHTMLPage(w, p)
+}
+
+// handleRPCNode responds to requests for /rpcnode/
+// It renders a sortable table of a directly-connected RPC node's peers at /rpcnode/<id>/peers (or returns them as
+// JSON when format=json), and a peer's details at /rpcnode/<id>/peer/<peer id>.
+func handleRPCNode(w http.ResponseWriter, r *http.Request) {
+	// For r.URL.Path of /rpcnode/0/peer/12, parts will be: ["", "rpcnode", "0", "peer", "12"]
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 {
+		renderHTMLErr(w, newHTTPError(http.StatusNotFound, "page %s not found", r.URL.Path))
+		return
+	}
+
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid rpc node id '%s'", parts[2]))
+		return
+	}
+
+	switch {
+	case len(parts) == 4 && parts[3] == "peers":
+		values := r.URL.Query()
+		q := peerQuery{
+			RPCNode: id,
+			Sort: values.Get("sort"),
+			Desc: values.Get("order") == "desc",
+		}
+		if len(q.Sort) == 0 {
+			q.Sort = "id"
+		}
+
+		table, err := peerTable(q)
+
+		if values.Get("format") == "json" {
+			if err != nil {
+				http.Error(w, err.Error(), errStatus(rpcError(err)))
+				return
+			}
+			renderJSON(w, table.Peers)
+			return
+		}
+
+		if err != nil {
+			renderHTMLErr(w, rpcError(err))
+			return
+		}
+
+		p := newHTMLPage("soterdash - rpc node peers")
+		renderHTML(p, "<br>", nil)
+		table.RenderHTML(p)
+		writeHTMLPage(w, p)
+	case len(parts) == 5 && parts[3] == "peer":
+		peerID, err := strconv.ParseInt(parts[4], 10, 32)
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "invalid peer id '%s'", parts[4]))
+			return
+		}
+
+		peer, err := peerInfo(id, int32(peerID))
+		if err != nil {
+			renderHTMLErr(w, rpcError(err))
+			return
+		}
+
+		p := newHTMLPage("soterdash - rpc node peer")
+		renderHTML(p, "<br>", nil)
+		peer.RenderHTML(p)
+		writeHTMLPage(w, p)
+	default:
+		renderHTMLErr(w, newHTTPError(http.StatusNotFound, "page %s not found", r.URL.Path))
+	}
+}
+
+// handleRPCPeerGraph responds to requests for /peergraph
+// It renders the graph of the RPC nodes' peer connections, compared with the census.
+func handleRPCPeerGraph(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - peer graph"
+
+	info, err := peerGraphInfo()
+	if err != nil {
+		renderHTMLErr(w, rpcError(err))
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	info.RenderHTML(p)
+	writeHTMLPage(w, p)
+}
+
+// handleAdminWorkers responds to requests for /admin/workers
+// It renders the census worker pool status, and changes the pool when a form is posted.
+func handleAdminWorkers(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - census workers"
+
+	if r.Method == http.MethodPost {
+		err := updateWorkers(r)
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "%s", err))
+			return
 		}
 
-		info.Id = id
-		info.RenderHTML(w)
+		// Redirect so that reloading the page doesn't re-submit the form
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
 	}
 
-	// Render HTML sections after the body
-	afterBody(w)
-}
\ No newline at end of file
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	renderHTMLTmpl(p, "admin_workers.tmpl", e.WorkerStatus())
+	writeHTMLPage(w, p)
+}
+
+// updateWorkers applies a census worker pool change posted to /admin/workers
+func updateWorkers(r *http.Request) error {
+	status := e.WorkerStatus()
+
+	switch action := r.FormValue("action"); action {
+	case "add":
+		return e.SetWorkers(status.Target + 1)
+	case "remove":
+		if status.Target == 0 {
+			return fmt.Errorf("there are no workers to remove")
+		}
+		return e.SetWorkers(status.Target - 1)
+	case "set":
+		count, err := parseFormInt(r, "count")
+		if err != nil {
+			return err
+		}
+		return e.SetWorkers(count)
+	case "autoscale":
+		min, err := parseFormInt(r, "min")
+		if err != nil {
+			return err
+		}
+		max, err := parseFormInt(r, "max")
+		if err != nil {
+			return err
+		}
+		return e.Autoscale(r.FormValue("enable") == "true", min, max)
+	default:
+		return fmt.Errorf("unknown action '%s'", action)
+	}
+}
+
+// handleAdminSeeds responds to requests for /admin/seeds
+// It renders the census seed nodes, and adds a seed when a form is posted.
+func handleAdminSeeds(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - census seeds"
+
+	if r.Method == http.MethodPost {
+		err := e.AddSeed(r.FormValue("address"), "admin")
+		if err != nil {
+			renderHTMLErr(w, newHTTPError(http.StatusBadRequest, "%s", err))
+			return
+		}
+
+		// Redirect so that reloading the page doesn't re-submit the form
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	renderHTMLTmpl(p, "admin_seeds.tmpl", e.Seeds())
+	writeHTMLPage(w, p)
+}
+
+// handleFederationReport responds to requests for /federation/report
+// Census agents POST their census reports here, authenticated with the federation token.
+func handleFederationReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(federationToken) == 0 {
+		http.Error(w, "census reports aren't accepted, because no federation token is set", http.StatusForbidden)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(federationToken)) != 1 {
+		http.Error(w, "invalid federation token", http.StatusUnauthorized)
+		return
+	}
+
+	var report census.Report
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReportSize)).Decode(&report)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid census report: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	err = e.Merge(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFederation responds to requests for /federation
+// It renders the census agents that have pushed reports to us.
+func handleFederation(w http.ResponseWriter, r *http.Request) {
+	title := "soterdash - census agents"
+
+	p := newHTMLPage(title)
+	renderHTML(p, "<br>", nil)
+	renderHTMLTmpl(p, "federation.tmpl", e.Agents())
+	writeHTMLPage(w, p)
+}
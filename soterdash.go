@@ -14,19 +14,37 @@ import (
 	"os/signal"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/soteria-dag/soterdash/census"
+	"github.com/soteria-dag/soterdash/phantom"
 	"github.com/soteria-dag/soterdash/rand"
 	"github.com/soteria-dag/soterd/chaincfg"
 	"github.com/soteria-dag/soterd/rpcclient"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
 )
 
 var (
 	// Holds the directly-connected RPC clients
 	clients []*rpcclient.Client
+	// The parameters of the soterd network we're monitoring
+	activeNet *chaincfg.Params
 	// The census enumerator collects node connectivity info from participants in the p2p network
 	e *census.Enumerator
+	// Census agents must present this token to push reports to /federation/report. Reports aren't accepted when it's empty.
+	federationToken string
+	// Rolling history of the RPC nodes' network traffic
+	bandwidth *bandwidthHistory
+	// When each RPC node first saw each recent block
+	propagation = newPropagationTracker(propagationBlockLimit)
+	// Blue/red coloring flips seen in the RPC nodes' dags
+	colorTracker = newColoringTracker()
+	// The PHANTOM k value that the RPC nodes' dag coloring is verified with
+	coloringK = phantom.DefaultK
+	// Evaluates alert rules, and notifies webhooks of alerts
+	alerts *alertEngine
 )
 
 // pickClient returns a randomly-chosen client
@@ -74,7 +92,26 @@ func soterdP2PAddrs(c *rpcclient.Client) ([]string, []string, error) {
 	return me, addrs, nil
 }
 
-// TODO(cedric): Support connecting to more than one soterd node via RPC on startup
+// defaultAgentName returns the name to report as when running as a census agent, which is the hostname
+func defaultAgentName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "soterdash"
+	}
+
+	return name
+}
+
+// addSeeds adds the addresses to the census as seeds, logging any that couldn't be added
+func addSeeds(addrs []string, source string) {
+	for _, a := range addrs {
+		err := e.AddSeed(a, source)
+		if err != nil {
+			log.Printf("Skipping seed %s from %s: %s", a, source, err)
+		}
+	}
+}
+
 func main() {
 	// Determine what default soterd RPC certificate path should be
 	defaultSoterdCertPath, err := soterdCertPath()
@@ -82,80 +119,208 @@ func main() {
 		log.Fatalf("Couldn't determine what default soterd certificate path should be: %s", err)
 	}
 
-	// Parse cli flags
-	var mainnet, testnet, regnet, simnet bool
-	var addr, censusInterval, soterdAddr, soterdUser, soterdPass, soterdCertPath string
-	var censusWorkers int
+	// Parse cli flags, and apply them over the config file and environment variables
+	defaults := defaultConfig(defaultSoterdCertPath)
+	cfg := *defaults
+	defineFlags(flag.CommandLine, &cfg)
+	flag.Parse()
 
-	flag.StringVar(&addr, "l", ":5072", "Which [ip]:port to listen on")
-	flag.StringVar(&soterdAddr, "r", "", "Soterd RPC ip:port to connect to")
-	flag.StringVar(&soterdUser, "u", "", "Soterd RPC username")
-	flag.StringVar(&soterdPass, "p", "", "Soterd RPC password")
-	flag.StringVar(&soterdCertPath, "c", defaultSoterdCertPath, "Soterd RPC certificate path")
-	flag.IntVar(&censusWorkers, "w", 2, "Number of p2p network census workers to start")
-	flag.StringVar(&censusInterval, "i", "15s", "Time interval for polling nodes")
-	flag.BoolVar(&mainnet, "mainnet", false, "Use mainnet for soterd network census worker connections")
-	flag.BoolVar(&testnet, "testnet", false, "Use testnet for soterd network census worker connections")
-	flag.BoolVar(&regnet, "regnet", false, "Use regnet (regression test network) for soterd network census worker connections")
-	flag.BoolVar(&simnet, "simnet", false, "Use simnet for soterd network census worker connections")
+	if flag.Lookup("hashpass").Value.String() == "true" {
+		err = hashPassword(os.Stdin, os.Stdout)
+		if err != nil {
+			log.Fatalf("Failed to hash password: %s", err)
+		}
+		return
+	}
 
-	flag.Parse()
+	err = loadConfig(flag.CommandLine, &cfg, defaults)
+	if err != nil {
+		log.Fatalf("Failed to load config: %s", err)
+	}
+
+	if flag.Lookup("dump-config").Value.String() == "true" {
+		err = cfg.dump(os.Stdout)
+		if err != nil {
+			log.Fatalf("Failed to dump config: %s", err)
+		}
+		return
+	}
 
-	interval, err := time.ParseDuration(censusInterval)
+	federationToken = cfg.Federation.Token
+	brand = cfg.UI.Brand
+	coloringK = cfg.RPC.ColoringK
+
+	interval, err := time.ParseDuration(cfg.Census.Interval)
 	if err != nil {
-		log.Fatalf("Failed to parse census interval '%s': %s", censusInterval, err)
+		log.Fatalf("Failed to parse census interval '%s': %s", cfg.Census.Interval, err)
 	}
 
-	// Pick soterd census worker net params
-	var net chaincfg.Params
-	netCount := 0
-	if mainnet {
-		net = chaincfg.MainNetParams
-		netCount++
+	snapInterval, err := time.ParseDuration(cfg.Census.Snapshots)
+	if err != nil {
+		log.Fatalf("Failed to parse snapshot interval '%s': %s", cfg.Census.Snapshots, err)
 	}
-	if testnet {
-		net = chaincfg.TestNet1Params
-		netCount++
+
+	snapRetention, err := time.ParseDuration(cfg.Census.SnapshotKeep)
+	if err != nil {
+		log.Fatalf("Failed to parse snapshot retention '%s': %s", cfg.Census.SnapshotKeep, err)
 	}
-	if regnet {
-		net = chaincfg.RegressionNetParams
-		netCount++
+
+	pushEvery, err := time.ParseDuration(cfg.Federation.PushInterval)
+	if err != nil {
+		log.Fatalf("Failed to parse push interval '%s': %s", cfg.Federation.PushInterval, err)
 	}
-	if simnet {
-		net = chaincfg.SimNetParams
-		netCount++
+
+	bwInterval, err := time.ParseDuration(cfg.RPC.BandwidthInterval)
+	if err != nil {
+		log.Fatalf("Failed to parse bandwidth sampling interval '%s': %s", cfg.RPC.BandwidthInterval, err)
 	}
-	if netCount == 0 {
-		log.Fatalf("must choose p2p network for soterd census workers (-mainnet, -testnet, -regnet, -simnet)")
+
+	bwRetention, err := time.ParseDuration(cfg.RPC.BandwidthKeep)
+	if err != nil {
+		log.Fatalf("Failed to parse bandwidth history retention '%s': %s", cfg.RPC.BandwidthKeep, err)
 	}
-	if netCount > 1 {
-		log.Fatalf("must choose only one p2p network for soterd census workers (-mainnet, -testnet, -regnet, -simnet)")
+
+	metricsEvery, err := time.ParseDuration(cfg.Metrics.Interval)
+	if err != nil {
+		log.Fatalf("Failed to parse metrics interval '%s': %s", cfg.Metrics.Interval, err)
 	}
 
-	// Read Soterd RPC certificate
-	cert, err := ioutil.ReadFile(soterdCertPath)
+	coloringEvery, err := time.ParseDuration(cfg.RPC.ColoringInterval)
+	if err != nil {
+		log.Fatalf("Failed to parse coloring interval '%s': %s", cfg.RPC.ColoringInterval, err)
+	}
+
+	addrFilter, err := census.ParseAddressFilter(strings.Join(cfg.Census.Allow, ","), strings.Join(cfg.Census.Deny, ","))
 	if err != nil {
-		log.Fatalf("Failed to load certificate %s: %s", soterdCertPath, err)
+		log.Fatalf("Failed to parse census address filter: %s", err)
 	}
 
-	// Connect to soterd node
-	rpcCfg := rpcclient.ConnConfig{
-		Host: soterdAddr,
-		Endpoint: "ws",
-		User: soterdUser,
-		Pass: soterdPass,
-		Certificates: cert,
+	geoDB, err := census.OpenGeoDB(cfg.Census.GeoDB, cfg.Census.ASNDB)
+	if err != nil {
+		log.Fatalf("Failed to open GeoIP databases: %s", err)
 	}
-	client, err := rpcclient.New(&rpcCfg, nil)
+	defer geoDB.Close()
+
+	// Pick soterd census worker net params
+	net, err := cfg.netParams()
 	if err != nil {
-		log.Fatalf("Failed to connect to soterd at %s: %s", soterdAddr, err)
+		log.Fatal(err)
 	}
-	clients = append(clients, client)
+	activeNet = net
 
-	// Determine listening p2p addresses of seed nodes
-	listen, peers, err := soterdP2PAddrs(client)
+	// Read Soterd RPC certificate
+	cert, err := ioutil.ReadFile(cfg.RPC.Cert)
 	if err != nil {
-		log.Fatalf("Failed to find soterd node listening interfaces: %s", err)
+		log.Fatalf("Failed to load certificate %s: %s", cfg.RPC.Cert, err)
+	}
+
+	// Set up the soterd p2p network census
+	e = census.New(nil, interval, cfg.Census.Workers, net)
+	e.SetAddressFilter(addrFilter)
+	e.SetGeoDB(geoDB)
+	err = e.SetSnapshots(snapInterval, snapRetention)
+	if err != nil {
+		log.Fatalf("Failed to configure census snapshots: %s", err)
+	}
+	if cfg.Census.Autoscale {
+		err = e.Autoscale(true, cfg.Census.MinWorkers, cfg.Census.MaxWorkers)
+		if err != nil {
+			log.Fatalf("Failed to configure census worker autoscaling: %s", err)
+		}
+	}
+
+	// Connect to soterd nodes, and seed the census from their listening addresses and peers.
+	// A node that can't be reached is skipped, so that the dashboard and census can still run.
+	for _, soterdAddr := range cfg.RPC.Nodes {
+		rpcCfg := rpcclient.ConnConfig{
+			Host: soterdAddr,
+			Endpoint: "ws",
+			User: cfg.RPC.User,
+			Pass: cfg.RPC.Pass,
+			Certificates: cert,
+		}
+		// Record when this node is notified of each block, for measuring block propagation
+		id := len(clients)
+		handlers := rpcclient.NotificationHandlers{
+			OnFilteredBlockConnected: func(height int32, header *wire.BlockHeader, txs []*soterutil.Tx) {
+				propagation.seen(id, header.BlockHash().String(), height, time.Now())
+			},
+		}
+
+		client, err := rpcclient.New(&rpcCfg, &handlers)
+		if err != nil {
+			log.Printf("Failed to connect to soterd at %s: %s", soterdAddr, err)
+			continue
+		}
+		clients = append(clients, client)
+
+		err = client.NotifyBlocks()
+		if err != nil {
+			log.Printf("Failed to register for block notifications from soterd at %s: %s", soterdAddr, err)
+		}
+
+		listen, peers, err := soterdP2PAddrs(client)
+		if err != nil {
+			log.Printf("Failed to find soterd node listening interfaces: %s", err)
+			continue
+		}
+
+		source := fmt.Sprintf("rpc node %s", soterdAddr)
+		addSeeds(listen, source)
+		addSeeds(peers, source)
+	}
+
+	// Keep a history of the RPC nodes' network traffic
+	bandwidth = newBandwidthHistory(bwRetention)
+	bwPoller := newBandwidthPoller(bandwidth, bwInterval)
+	bwPoller.Start()
+
+	// Track the RPC nodes' dag coloring, to record blocks flipping between blue and red
+	coloringPoll := newColoringPoller(coloringEvery)
+	coloringPoll.Start()
+
+	// Poll RPC nodes for Prometheus metrics
+	metricsPoll := newMetricsPoller(metricsEvery)
+	metricsPoll.Start()
+
+	// Evaluate alert rules
+	alerts, err = newAlertEngine(cfg.Alerts)
+	if err != nil {
+		log.Fatalf("Failed to set up alerts: %s", err)
+	}
+
+	if len(cfg.Census.SeedFile) > 0 {
+		addrs, err := census.ReadSeedFile(cfg.Census.SeedFile)
+		if err != nil {
+			log.Fatalf("Failed to read seed file %s: %s", cfg.Census.SeedFile, err)
+		}
+		addSeeds(addrs, "seed file")
+	}
+
+	if cfg.Census.DNSSeed {
+		addrs, errs := census.LookupDNSSeeds(net, census.NewResolver(cfg.Census.DNSResolver))
+		for _, err := range errs {
+			log.Printf("Failed to look up DNS seed: %s", err)
+		}
+		addSeeds(addrs, "dns seed")
+	}
+
+	if len(e.Seeds()) == 0 {
+		log.Println("No census seeds were found. Seeds can be added from the /admin/seeds page.")
+	}
+
+	// Push our census to a central soterdash, when running as an agent
+	var agent *census.Agent
+	if len(cfg.Federation.Push) > 0 {
+		if len(federationToken) == 0 {
+			log.Fatalf("A federation token (-fedtoken) is needed to push census reports")
+		}
+
+		reportURL := strings.TrimRight(cfg.Federation.Push, "/") + "/federation/report"
+		agent, err = census.NewAgent(e, cfg.Federation.Agent, reportURL, federationToken, pushEvery)
+		if err != nil {
+			log.Fatalf("Failed to set up census agent: %s", err)
+		}
 	}
 
 	// Route requests for / (or anything that doesn't match another pattern) to handleRoot, in DefaultServeMux.
@@ -164,24 +329,79 @@ func main() {
 	// Show block details
 	// The trailing / allows us to route requests for URLs like /block/09d41fa to handleBlock
 	http.HandleFunc("/block/", handleBlock)
+	// Show transaction details
+	http.HandleFunc("/tx/", handleTx)
+	// Show RPC nodes' unconfirmed transactions
+	http.HandleFunc("/mempool", handleMempool)
+	// Show blocks that flipped between blue and red in the RPC nodes' dag coloring
+	http.HandleFunc("/coloring", handleColoring)
+	// Verify the RPC nodes' dag coloring against an independently computed blue set
+	http.HandleFunc("/verify", handleVerify)
+	// Chart difficulty against block interval, with retarget points and the expected next target
+	http.HandleFunc("/difficulty", handleDifficulty)
+	// Show alert rules, and active and recent alerts
+	http.HandleFunc("/alerts", handleAlerts)
+	// Show block propagation timing across RPC nodes
+	http.HandleFunc("/propagation", handlePropagation)
 	// Render dag with min, max height, and pagination support
 	http.HandleFunc("/dag", handleDag)
 	http.HandleFunc("/favicon.ico", handleFavicon)
 	// Show census-enumerated node details
 	http.HandleFunc("/node/", handleNode)
+	// Stream census events (node discovered, offline, online, version changed)
+	http.HandleFunc("/events", handleEvents)
+	// Table of census-enumerated nodes, with filtering, sorting and downloads
+	http.HandleFunc("/nodes", handleNodes)
 	// Graph census-enumerated node connectivity
 	http.HandleFunc("/nodegraph", handleNodeGraph)
+	// Show what changed in the census between two points in time
+	http.HandleFunc("/census/diff", handleCensusDiff)
+	// Accept census reports from agents, and show the agents that have reported
+	http.HandleFunc("/federation/report", handleFederationReport)
+	http.HandleFunc("/federation", handleFederation)
+	// Show and change the census worker pool
+	http.HandleFunc("/admin/workers", handleAdminWorkers)
+	// Show and add census seeds
+	http.HandleFunc("/admin/seeds", handleAdminSeeds)
+	// Show partition and single-point-of-failure analysis of the census-enumerated node graph
+	http.HandleFunc("/topology", handleTopology)
 	// Show directly-connected RPC node details
 	http.HandleFunc("/rpcnodes", handleRPCNodes)
+	// Show directly-connected RPC nodes' peers
+	http.HandleFunc("/rpcnode/", handleRPCNode)
+	// Graph directly-connected RPC nodes' peer connections, and compare them with the census
+	http.HandleFunc("/peergraph", handleRPCPeerGraph)
+	// JSON API for dashboard resources, described by /api/v1/openapi.json
+	http.HandleFunc(apiPrefix, handleAPI)
+	// Prometheus metrics for RPC nodes, the census and http requests
+	http.Handle("/metrics", handleMetrics)
 	// Serve file contents from static folder
 	http.HandleFunc("/static/", handleStatic)
 
+	// Require authentication for DefaultServeMux, if users or tokens are configured
+	handler, err := newAuthHandler(http.DefaultServeMux, cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to set up authentication: %s", err)
+	}
+	handler = instrumentHTTP(handler)
+
+	if cfg.TLS.Generate {
+		err = ensureSelfSignedCert(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			log.Fatalf("Failed to generate TLS certificate: %s", err)
+		}
+	}
+
 	// Start http server in a goroutine, so that it doesn't block our flow for starting census
 	// or other background activities. We'll use a channel to let us know if there was a problem encountered.
 	httpSrvResult := make(chan error)
 	startHttp := func() {
-		// Use DefaultServeMux as the handler
-		err := http.ListenAndServe(addr, nil)
+		var err error
+		if len(cfg.TLS.Cert) > 0 {
+			err = http.ListenAndServeTLS(cfg.Listen, cfg.TLS.Cert, cfg.TLS.Key, handler)
+		} else {
+			err = http.ListenAndServe(cfg.Listen, handler)
+		}
 		httpSrvResult <- err
 	}
 
@@ -189,21 +409,15 @@ func main() {
 
 	// Start the soterd p2p network census
 	log.Println("Starting soterd p2p network census")
-	seedNodes := make([]*census.Node, 0)
-	for _, a := range listen {
-		cn := census.Node{
-			Address: a,
-		}
-		seedNodes = append(seedNodes, &cn)
-	}
-	for _, a := range peers {
-		cn := census.Node{
-			Address: a,
-		}
-		seedNodes = append(seedNodes, &cn)
-	}
-	e = census.New(seedNodes, interval, censusWorkers, &net)
 	e.Start()
+	if agent != nil {
+		log.Printf("Pushing census reports to %s as agent %s", cfg.Federation.Push, cfg.Federation.Agent)
+		agent.Start()
+	}
+
+	// Start evaluating alert rules
+	log.Printf("Evaluating %d alert rules", len(alerts.rules))
+	alerts.Start()
 
 	// Listen for signals telling us to shut down, or for http server to stop
 	c := make(chan os.Signal, 1)
@@ -212,13 +426,22 @@ func main() {
 	select {
 		case err := <-httpSrvResult:
 			if err != nil {
-				log.Printf("Failed to ListenAndServe for addr %s: %s")
+				log.Printf("Failed to ListenAndServe for addr %s: %s", cfg.Listen, err)
 			}
 		case s := <-c:
 			log.Println("Shutting down due to signal:", s)
 	}
 
+	// Stop sampling network traffic, polling for coloring and metrics, and evaluating alerts
+	bwPoller.Stop()
+	coloringPoll.Stop()
+	metricsPoll.Stop()
+	alerts.Stop()
+
 	// Stop census
+	if agent != nil {
+		agent.Stop()
+	}
 	e.Stop()
 }
 
@@ -0,0 +1,192 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/soteria-dag/soterdash/phantom"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/rpcclient"
+)
+
+const (
+	// How many generations below the tips are verified by default
+	verifyDefaultRange = int32(10)
+
+	// How many generations below the verified range are fetched, so that the range's coloring is anchored in its history
+	verifyAnchorDepth = int32(50)
+
+	// The most generations that are fetched from an RPC node for a verification, from the bottom of the anchor to the
+	// node's latest block
+	verifyFetchLimit = int32(500)
+
+	// How many times a node's coloring is fetched, if its dag keeps changing while it's being fetched
+	verifyAttempts = 3
+)
+
+// coloringMismatch is a block whose coloring reported by an RPC node doesn't match the independently computed one
+type coloringMismatch struct {
+	phantom.Mismatch
+	Height int32
+}
+
+// Reported returns the block's color in the RPC node's coloring
+func (m coloringMismatch) Reported() string {
+	if m.Missing {
+		return "missing"
+	}
+	if m.ReportedBlue {
+		return "blue"
+	}
+	return "red"
+}
+
+// Computed returns the block's color in the independently computed coloring
+func (m coloringMismatch) Computed() string {
+	if m.ComputedBlue {
+		return "blue"
+	}
+	return "red"
+}
+
+// nodeVerification is the result of verifying an RPC node's dag coloring
+type nodeVerification struct {
+	RPCNode int
+	// The node's latest block, whose past the coloring is of
+	Best string
+	BestHeight int32
+	// How many blocks in the range were compared, and how many of them were computed to be blue
+	Blocks int
+	Blue int
+	Mismatches []coloringMismatch
+	// Why the node couldn't be verified, if it couldn't
+	Err string
+}
+
+// fetchDAG returns the RPC node's blocks between the heights, and the height of each block
+func fetchDAG(c *rpcclient.Client, minHeight, maxHeight int32) (*phantom.DAG, map[string]int32, error) {
+	dag := phantom.NewDAG()
+	heights := make(map[string]int32)
+
+	for height := minHeight; height <= maxHeight; height++ {
+		hashes, err := c.GetBlockHash(int64(height))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, hash := range hashes {
+			block, err := c.GetBlock(hash)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			parents := make([]string, len(block.Parents.Parents))
+			for i, p := range block.Parents.Parents {
+				parents[i] = p.Hash.String()
+			}
+
+			dag.Add(hash.String(), parents)
+			heights[hash.String()] = height
+		}
+	}
+
+	return dag, heights, nil
+}
+
+// verifyColoring compares the RPC node's dag coloring of the blocks between the heights with a blue set computed from
+// the node's blocks, using the k value.
+func verifyColoring(c *rpcclient.Client, k int, minHeight, maxHeight int32) (nodeVerification, error) {
+	v := nodeVerification{
+		RPCNode: clientID(c),
+		Mismatches: make([]coloringMismatch, 0),
+	}
+
+	// The node reports the coloring of its latest block's past. Make sure the latest block didn't change while the
+	// coloring was fetched, so that the coloring is compared with the right blue set.
+	var best *chainhash.Hash
+	var reported map[string]bool
+	for attempt := 1; ; attempt++ {
+		before, err := c.GetBestBlockHash()
+		if err != nil {
+			return v, err
+		}
+
+		coloring, _, err := fetchColoring(c)
+		if err != nil {
+			return v, err
+		}
+
+		after, err := c.GetBestBlockHash()
+		if err != nil {
+			return v, err
+		}
+
+		if before.IsEqual(after) {
+			best = after
+			reported = make(map[string]bool)
+			for _, b := range coloring {
+				reported[b.Hash] = b.IsBlue
+			}
+			break
+		}
+		if attempt >= verifyAttempts {
+			return v, fmt.Errorf("latest block kept changing while the dag coloring was fetched")
+		}
+	}
+
+	header, err := c.GetBlockHeaderVerbose(best)
+	if err != nil {
+		return v, err
+	}
+	v.Best = best.String()
+	v.BestHeight = header.Height
+
+	// Blocks above the latest block aren't in its past, so they're fetched only to be compared
+	top := maxHeight
+	if v.BestHeight > top {
+		top = v.BestHeight
+	}
+	bottom := minHeight - verifyAnchorDepth
+	if bottom < 0 {
+		bottom = 0
+	}
+	if top-bottom >= verifyFetchLimit {
+		return v, fmt.Errorf("can't fetch more than %d generations; latest block is at height %d", verifyFetchLimit, v.BestHeight)
+	}
+
+	dag, heights, err := fetchDAG(c, bottom, top)
+	if err != nil {
+		return v, err
+	}
+
+	blue := phantom.NewColorer(dag, k).BlueSet(v.Best)
+
+	blocks := make([]string, 0)
+	for hash, height := range heights {
+		if height >= minHeight && height <= maxHeight {
+			blocks = append(blocks, hash)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		if heights[blocks[i]] != heights[blocks[j]] {
+			return heights[blocks[i]] < heights[blocks[j]]
+		}
+		return blocks[i] < blocks[j]
+	})
+
+	v.Blocks = len(blocks)
+	for _, hash := range blocks {
+		if blue[hash] {
+			v.Blue++
+		}
+	}
+	for _, m := range phantom.Compare(blocks, blue, reported) {
+		v.Mismatches = append(v.Mismatches, coloringMismatch{Mismatch: m, Height: heights[m.Hash]})
+	}
+
+	return v, nil
+}